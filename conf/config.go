@@ -2,65 +2,295 @@ package conf
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/BurntSushi/toml"
+
+	"github.com/filecoin-project/go-address"
 )
 
-var config *Config
+const (
+	// EnvConfig names the config file, the --config flag sets it too
+	EnvConfig = "LOTUS_TOOLS_CONFIG"
+	// EnvNetwork selects the network profile, the --network flag sets it too
+	EnvNetwork = "LOTUS_TOOLS_NETWORK"
+)
+
+var (
+	config      *Config
+	configPath  string
+	networkName string
+)
 
 type Config struct {
-	Repo  Repo
-	Actor Actor
+	// Network selects one of Networks, it is empty when no profile is used
+	Network  string             `toml:"network"`
+	Networks map[string]Network `toml:"networks"`
+
+	Repo   Repo
+	Actor  Actor
+	Wallet Wallet
+}
+
+// Network is a network profile, its fields replace the ones in [Repo] so
+// every network has its own keystore
+type Network struct {
+	FullNodeApi string `toml:"full_node_api"`
+	ToolPath    string `toml:"tool_path"`
+	// AddressNetwork is "mainnet" or "testnet", by default "mainnet" for the
+	// profile named mainnet and "testnet" for any other
+	AddressNetwork string `toml:"address_network"`
 }
 
 type Actor struct {
-	Cids []string `json:"cids"`
+	Cids   []string      `json:"cids"`
+	Custom []CustomActor `toml:"custom"`
+}
+
+// CustomActor is the method table of an actor code CID unknown to the builtin
+// actor registry
+type CustomActor struct {
+	Code    string        `toml:"code"`
+	Methods []ActorMethod `toml:"methods"`
+}
+
+// ActorMethod describes one method, Params and Return name go-state-types
+// types like "v11/miner.WithdrawBalanceParams" or "abi.TokenAmount"
+type ActorMethod struct {
+	Number uint64 `toml:"number"`
+	Name   string `toml:"name"`
+	Params string `toml:"params"`
+	Return string `toml:"return"`
+}
+
+type Wallet struct {
+	Listen string `toml:"listen"`
 }
 
 type Repo struct {
-	ToolPath    string `toml:"tool_path"`
-	FullNodeApi string `toml:"full_node_api"`
+	ToolPath       string `toml:"tool_path"`
+	FullNodeApi    string `toml:"full_node_api"`
+	EncryptKeys    bool   `toml:"encrypt_keys"`
+	PassphraseFile string `toml:"passphrase_file"`
 }
 
-func initConfig() error {
-	currentDir, _ := os.Getwd()
-	configFile := filepath.Join(currentDir, "config.toml")
+// SetConfigPath sets the config file to load instead of searching for one,
+// it has to be called before the config is first used
+func SetConfigPath(path string) {
+	configPath = path
+}
 
-	metaData, err := toml.DecodeFile(configFile, &config)
+// SetNetwork selects a network profile, overriding the one set in the file
+func SetNetwork(name string) {
+	networkName = name
+}
+
+// AddressNetwork returns the network addresses are printed for
+func (c *Config) AddressNetwork() (address.Network, error) {
+	if c.Network == "" {
+		return address.Mainnet, nil
+	}
+
+	switch n := c.Networks[c.Network].AddressNetwork; n {
+	case "mainnet":
+		return address.Mainnet, nil
+	case "testnet":
+		return address.Testnet, nil
+	case "":
+		if c.Network == "mainnet" {
+			return address.Mainnet, nil
+		}
+		return address.Testnet, nil
+	default:
+		return 0, fmt.Errorf("network %s: address_network must be 'mainnet' or 'testnet', got %q", c.Network, n)
+	}
+}
+
+// GetConfig loads the config on first use: from the path set with
+// SetConfigPath or $LOTUS_TOOLS_CONFIG, otherwise from the first of
+// $XDG_CONFIG_HOME/lotus-tools/config.toml and ~/.lotus-tools/config.toml.
+// The selected network profile replaces the [Repo] fields, LOTUS_TOOLS_*
+// environment variables override both. Loading sets the address network.
+func GetConfig() (*Config, error) {
+	if config != nil {
+		return config, nil
+	}
+
+	cfg, err := loadConfig()
 	if err != nil {
-		return fmt.Errorf("failed load config file, path: %s, error: %w", configFile, err)
-	} else {
-		if !requiredFieldsAreGiven(metaData) {
-			log.Fatal("Required fields not given")
+		return nil, err
+	}
+
+	an, err := cfg.AddressNetwork()
+	if err != nil {
+		return nil, err
+	}
+	address.CurrentNetwork = an
+
+	config = cfg
+	return config, nil
+}
+
+func loadConfig() (*Config, error) {
+	path, err := findConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if path != "" {
+		if _, err := toml.DecodeFile(path, cfg); err != nil {
+			return nil, fmt.Errorf("failed load config file, path: %s, error: %w", path, err)
 		}
 	}
-	return nil
+
+	if err := applyNetwork(cfg); err != nil {
+		return nil, err
+	}
+	if err := applyEnv(cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.Repo.ToolPath, err = expandHome(cfg.Repo.ToolPath); err != nil {
+		return nil, err
+	}
+	if cfg.Repo.PassphraseFile, err = expandHome(cfg.Repo.PassphraseFile); err != nil {
+		return nil, err
+	}
+
+	if cfg.Repo.ToolPath == "" {
+		if path == "" {
+			return nil, fmt.Errorf("no config file found, create one at %s or pass --config", strings.Join(searchPaths(), " or "))
+		}
+		return nil, fmt.Errorf("required field [Repo] tool_path not given in %s", path)
+	}
+	return cfg, nil
 }
 
-func GetConfig() *Config {
-	if config == nil {
-		if err := initConfig(); err != nil {
-			log.Fatal(err)
+func applyNetwork(cfg *Config) error {
+	if networkName != "" {
+		cfg.Network = networkName
+	} else if v := os.Getenv(EnvNetwork); v != "" {
+		cfg.Network = v
+	}
+	if cfg.Network == "" {
+		return nil
+	}
+
+	n, ok := cfg.Networks[cfg.Network]
+	if !ok {
+		names := make([]string, 0, len(cfg.Networks))
+		for name := range cfg.Networks {
+			names = append(names, name)
 		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown network %q, configured networks: %s", cfg.Network, strings.Join(names, ", "))
 	}
-	return config
+
+	if n.FullNodeApi != "" {
+		cfg.Repo.FullNodeApi = n.FullNodeApi
+	}
+	if n.ToolPath != "" {
+		cfg.Repo.ToolPath = n.ToolPath
+	}
+	return nil
 }
 
-func requiredFieldsAreGiven(metaData toml.MetaData) bool {
-	requiredFields := [][]string{
-		{"Repo"},
+// findConfig returns the config file to load, an empty path if none exists in
+// the search paths
+func findConfig() (string, error) {
+	path := configPath
+	if path == "" {
+		path = os.Getenv(EnvConfig)
+	}
+	if path != "" {
+		path, err := expandHome(path)
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("config file: %w", err)
+		}
+		return path, nil
+	}
 
-		{"Repo", "tool_path"},
+	for _, p := range searchPaths() {
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
 	}
+	return "", nil
+}
+
+func searchPaths() []string {
+	var paths []string
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		paths = append(paths, filepath.Join(xdg, "lotus-tools", "config.toml"))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".lotus-tools", "config.toml"))
+	}
+	return paths
+}
 
-	for _, v := range requiredFields {
-		if !metaData.IsDefined(v...) {
-			log.Fatal("Required fields ", v)
+// envOverrides maps environment variables to the config fields they override
+var envOverrides = map[string]func(*Config, string) error{
+	"LOTUS_TOOLS_TOOL_PATH": func(c *Config, v string) error {
+		c.Repo.ToolPath = v
+		return nil
+	},
+	"LOTUS_TOOLS_FULL_NODE_API": func(c *Config, v string) error {
+		c.Repo.FullNodeApi = v
+		return nil
+	},
+	"LOTUS_TOOLS_ENCRYPT_KEYS": func(c *Config, v string) (err error) {
+		c.Repo.EncryptKeys, err = strconv.ParseBool(v)
+		return err
+	},
+	"LOTUS_TOOLS_PASSPHRASE_FILE": func(c *Config, v string) error {
+		c.Repo.PassphraseFile = v
+		return nil
+	},
+	"LOTUS_TOOLS_WALLET_LISTEN": func(c *Config, v string) error {
+		c.Wallet.Listen = v
+		return nil
+	},
+	// comma separated, custom method tables can only be set in the file
+	"LOTUS_TOOLS_ACTOR_CIDS": func(c *Config, v string) error {
+		c.Actor.Cids = nil
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				c.Actor.Cids = append(c.Actor.Cids, s)
+			}
+		}
+		return nil
+	},
+}
+
+func applyEnv(cfg *Config) error {
+	for name, set := range envOverrides {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := set(cfg, v); err != nil {
+			return fmt.Errorf("parsing %s: %w", name, err)
 		}
 	}
+	return nil
+}
 
-	return true
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("expanding %s: %w", path, err)
+	}
+	return filepath.Join(home, path[1:]), nil
 }
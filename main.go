@@ -5,6 +5,7 @@ import (
 	cliutil "github.com/filecoin-project/lotus/cli/util"
 	logging "github.com/ipfs/go-log/v2"
 	ucli "github.com/urfave/cli/v2"
+	"lotus-tools/conf"
 	"lotus-tools/service"
 )
 
@@ -17,6 +18,16 @@ func main() {
 		Version:              "v1.0",
 		EnableBashCompletion: true,
 		Flags: []ucli.Flag{
+			&ucli.StringFlag{
+				Name:    "config",
+				EnvVars: []string{conf.EnvConfig},
+				Usage:   "config file, default: $XDG_CONFIG_HOME/lotus-tools/config.toml or ~/.lotus-tools/config.toml",
+			},
+			&ucli.StringFlag{
+				Name:    "network",
+				EnvVars: []string{conf.EnvNetwork},
+				Usage:   "network profile from the [networks] config section, e.g. mainnet or calibnet",
+			},
 			&ucli.StringFlag{
 				Name:    "panic-reports",
 				EnvVars: []string{"LOTUS_PANIC_REPORT_PATH"},
@@ -32,7 +43,18 @@ func main() {
 			},
 			cliutil.FlagVeryVerbose,
 		},
-		Commands: []*ucli.Command{service.SendCmd, service.WalletCmd},
+		Before: func(cctx *ucli.Context) error {
+			conf.SetConfigPath(cctx.String("config"))
+			conf.SetNetwork(cctx.String("network"))
+
+			// load early so addresses print for the selected network, commands
+			// report a missing config themselves
+			if _, err := conf.GetConfig(); err != nil && (cctx.IsSet("config") || cctx.IsSet("network")) {
+				return err
+			}
+			return nil
+		},
+		Commands: []*ucli.Command{service.SendCmd, service.WalletCmd, service.SignMessageCmd, service.PushCmd, service.MpoolCmd, service.AuditCmd, service.ActorCmd, service.MsgCmd, service.MinerCmd, service.MsigCmd, service.MarketCmd},
 	}
 	app.Setup()
 	lcli.RunApp(app)
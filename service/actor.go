@@ -0,0 +1,218 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/vm"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/filecoin-project/lotus/lib/tablewriter"
+)
+
+var ActorCmd = &cli.Command{
+	Name:  "actor",
+	Usage: "Inspect on-chain actors",
+	Subcommands: []*cli.Command{
+		actorMethodsCmd,
+	},
+}
+
+var actorMethodsCmd = &cli.Command{
+	Name:      "methods",
+	Usage:     "Print the methods of an actor and the JSON schema of their parameters",
+	ArgsUsage: "<address>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "no-schema",
+			Usage: "only print the method table",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+
+		addr, err := address.NewFromString(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		srv, err := NewLotusService(cctx)
+		if err != nil {
+			return err
+		}
+		defer srv.Close() //nolint:errcheck
+
+		ctx := lcli.ReqContext(cctx)
+		afmt := lcli.NewAppFmt(cctx.App)
+
+		code, methods, err := srv.ActorMethodTable(ctx, addr)
+		if err != nil {
+			return err
+		}
+
+		afmt.Printf("Actor: %s\n", addr)
+		afmt.Printf("Code:  %s (%s)\n\n", code, actorName(code))
+
+		tw := tablewriter.New(
+			tablewriter.Col("Number"),
+			tablewriter.Col("Name"),
+			tablewriter.Col("Params"),
+			tablewriter.Col("Return"))
+
+		nums := sortedMethodNums(methods)
+		for _, num := range nums {
+			m := methods[num]
+			tw.Write(map[string]interface{}{
+				"Number": num,
+				"Name":   m.Name,
+				"Params": typeString(m.Params),
+				"Return": typeString(m.Ret),
+			})
+		}
+		if err := tw.Flush(cctx.App.Writer); err != nil {
+			return err
+		}
+
+		if cctx.Bool("no-schema") {
+			return nil
+		}
+
+		for _, num := range nums {
+			m := methods[num]
+			if m.Params == nil || m.Params == reflect.TypeOf(new(abi.EmptyValue)) {
+				continue
+			}
+
+			schema, err := json.MarshalIndent(jsonSchema(m.Params, nil), "", "  ")
+			if err != nil {
+				return err
+			}
+			afmt.Printf("\n%s (%d) params:\n%s\n", m.Name, num, schema)
+		}
+		return nil
+	},
+}
+
+func actorName(code cid.Cid) string {
+	name := builtin.ActorNameByCode(code)
+	if name == "<unknown>" {
+		return "custom"
+	}
+	return name
+}
+
+func sortedMethodNums(methods map[abi.MethodNum]vm.MethodMeta) []abi.MethodNum {
+	nums := make([]abi.MethodNum, 0, len(methods))
+	for num := range methods {
+		nums = append(nums, num)
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+	return nums
+}
+
+func typeString(t reflect.Type) string {
+	if t == nil {
+		return "-"
+	}
+	if name := paramTypeName(t); name != "" {
+		return name
+	}
+	return strings.TrimPrefix(t.String(), "*")
+}
+
+var (
+	addressType  = reflect.TypeOf(address.Address{})
+	bigIntType   = reflect.TypeOf(big.Int{})
+	cidType      = reflect.TypeOf(cid.Cid{})
+	bitfieldType = reflect.TypeOf(bitfield.BitField{})
+)
+
+// jsonSchema describes the JSON encoding of t, as accepted by --params-json
+func jsonSchema(t reflect.Type, seen map[reflect.Type]bool) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case addressType:
+		return map[string]interface{}{"type": "string", "description": "address"}
+	case bigIntType:
+		return map[string]interface{}{"type": "string", "description": "integer, token amounts in attoFIL"}
+	case cidType:
+		return map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"/": map[string]interface{}{"type": "string"}},
+		}
+	case bitfieldType:
+		return map[string]interface{}{
+			"type":        "array",
+			"items":       map[string]interface{}{"type": "integer"},
+			"description": "run lengths of the bitfield, starting with unset bits",
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "description": "base64 encoded bytes"}
+		}
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem(), seen)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return map[string]interface{}{"type": "object", "description": t.String()}
+		}
+		if seen == nil {
+			seen = map[reflect.Type]bool{}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		props := map[string]interface{}{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+
+			name := f.Name
+			if tag, ok := f.Tag.Lookup("json"); ok {
+				if tag == "-" {
+					continue
+				}
+				if n := strings.Split(tag, ",")[0]; n != "" {
+					name = n
+				}
+			}
+			props[name] = jsonSchema(f.Type, seen)
+			required = append(required, name)
+		}
+		return map[string]interface{}{
+			"type":       "object",
+			"properties": props,
+			"required":   required,
+		}
+	default:
+		return map[string]interface{}{"description": t.String()}
+	}
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	actorstypes "github.com/filecoin-project/go-state-types/actors"
+	"github.com/filecoin-project/lotus/chain/actors"
+	"github.com/filecoin-project/lotus/chain/consensus/filcns"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+
+	"lotus-tools/conf"
+)
+
+const stateTypesPkg = "github.com/filecoin-project/go-state-types/"
+
+// defaultActorsVersion is the newest actors version known to go-state-types
+const defaultActorsVersion = actorstypes.Version11
+
+// legacyActorMethods is the method table used for the code CIDs listed in
+// [actor].cids, custom miner actors only exposing these two methods. The
+// params use the miner types of the actors version av.
+func legacyActorMethods(av actorstypes.Version) []conf.ActorMethod {
+	return []conf.ActorMethod{
+		{Number: 16, Name: "WithdrawBalance", Params: fmt.Sprintf("v%d/miner.WithdrawBalanceParams", av), Return: "abi.TokenAmount"},
+		{Number: 23, Name: "ChangeOwnerAddress", Params: "address.Address", Return: "abi.EmptyValue"},
+	}
+}
+
+// ActorsVersion returns the actors version of the full node's network
+func (s *LotusService) ActorsVersion(ctx context.Context) (actorstypes.Version, error) {
+	nv, err := s.api.StateNetworkVersion(ctx, types.EmptyTSK)
+	if err != nil {
+		return 0, xerrors.Errorf("getting network version: %w", err)
+	}
+
+	av, err := actorstypes.VersionForNetwork(nv)
+	if err != nil {
+		return 0, err
+	}
+	if av > defaultActorsVersion {
+		return 0, xerrors.Errorf("network version %d uses actors v%d, this build supports up to v%d", nv, av, defaultActorsVersion)
+	}
+	return av, nil
+}
+
+// actorMethods returns the actor registry for the network of the full node,
+// it is loaded on first use
+func (s *LotusService) actorMethods(ctx context.Context) (map[cid.Cid]map[abi.MethodNum]vm.MethodMeta, error) {
+	s.methodsOnce.Do(func() {
+		s.methods, s.methodsErr = s.loadActorMethods(ctx)
+	})
+	return s.methods, s.methodsErr
+}
+
+func (s *LotusService) loadActorMethods(ctx context.Context) (map[cid.Cid]map[abi.MethodNum]vm.MethodMeta, error) {
+	config, err := conf.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg := config.Actor
+	if s.api == nil {
+		return loadActorMethods(cfg, defaultActorsVersion, nil)
+	}
+
+	nv, err := s.api.StateNetworkVersion(ctx, types.EmptyTSK)
+	if err != nil {
+		return nil, xerrors.Errorf("getting network version: %w", err)
+	}
+
+	av, err := actorstypes.VersionForNetwork(nv)
+	if err != nil || av < actorstypes.Version8 || av > defaultActorsVersion {
+		log.Warnf("network version %d is not supported, decoding with actors v%d: %v", nv, defaultActorsVersion, err)
+		return loadActorMethods(cfg, defaultActorsVersion, nil)
+	}
+
+	codes, err := s.api.StateActorCodeCIDs(ctx, nv)
+	if err != nil {
+		return nil, xerrors.Errorf("getting actor code cids for network version %d: %w", nv, err)
+	}
+
+	return loadActorMethods(cfg, av, codes)
+}
+
+// loadActorMethods builds the actor registry. codes are the builtin actor
+// code CIDs of the network by actor name, codes unknown to the builtin
+// registry get the methods of the same actor at version av. This covers
+// networks running other builtin actor bundles than the ones compiled in.
+func loadActorMethods(cfg conf.Actor, av actorstypes.Version, codes map[string]cid.Cid) (map[cid.Cid]map[abi.MethodNum]vm.MethodMeta, error) {
+	methods := filcns.NewActorRegistry().Methods
+	known := paramTypes(methods)
+
+	if len(codes) > 0 {
+		builtins := map[string]map[abi.MethodNum]vm.MethodMeta{}
+		for code, mm := range methods {
+			if name, v, ok := actors.GetActorMetaByCode(code); ok && v == av {
+				builtins[name] = mm
+			}
+		}
+
+		for name, code := range codes {
+			if _, known := methods[code]; known {
+				continue
+			}
+			mm, ok := builtins[name]
+			if !ok {
+				log.Warnf("no v%d methods for actor %s with code %s", av, name, code)
+				continue
+			}
+			methods[code] = mm
+		}
+	}
+
+	add := func(codeStr string, table []conf.ActorMethod) error {
+		code, err := cid.Decode(codeStr)
+		if err != nil {
+			return xerrors.Errorf("actor code cid %q: %w", codeStr, err)
+		}
+
+		mm := map[abi.MethodNum]vm.MethodMeta{}
+		for _, m := range table {
+			params, err := lookupParamType(known, m.Params)
+			if err != nil {
+				return xerrors.Errorf("actor %s method %d params: %w", code, m.Number, err)
+			}
+			ret, err := lookupParamType(known, m.Return)
+			if err != nil {
+				return xerrors.Errorf("actor %s method %d return: %w", code, m.Number, err)
+			}
+			if m.Name == "" {
+				return xerrors.Errorf("actor %s method %d has no name", code, m.Number)
+			}
+
+			mm[abi.MethodNum(m.Number)] = vm.MethodMeta{
+				Name:   m.Name,
+				Params: params,
+				Ret:    ret,
+			}
+		}
+		methods[code] = mm
+		return nil
+	}
+
+	for _, c := range cfg.Cids {
+		if err := add(c, legacyActorMethods(av)); err != nil {
+			return nil, err
+		}
+	}
+	for _, ca := range cfg.Custom {
+		if err := add(ca.Code, ca.Methods); err != nil {
+			return nil, err
+		}
+	}
+
+	return methods, nil
+}
+
+// paramTypes indexes every parameter and return type used by the builtin
+// actors by its name, see paramTypeName
+func paramTypes(methods map[cid.Cid]map[abi.MethodNum]vm.MethodMeta) map[string]reflect.Type {
+	known := map[string]reflect.Type{
+		"address.Address": reflect.TypeOf(new(address.Address)),
+		"abi.EmptyValue":  reflect.TypeOf(new(abi.EmptyValue)),
+		"abi.TokenAmount": reflect.TypeOf(new(abi.TokenAmount)),
+		"abi.CborBytes":   reflect.TypeOf(new(abi.CborBytes)),
+	}
+
+	for _, mm := range methods {
+		for _, m := range mm {
+			for _, t := range []reflect.Type{m.Params, m.Ret} {
+				if name := paramTypeName(t); name != "" {
+					if _, ok := known[name]; !ok {
+						known[name] = t
+					}
+				}
+			}
+		}
+	}
+	return known
+}
+
+// paramTypeName names a go-state-types parameter type relative to the
+// go-state-types module, with builtin actor types prefixed by their actors
+// version, e.g. "v11/miner.WithdrawBalanceParams" or "abi.EmptyValue"
+func paramTypeName(t reflect.Type) string {
+	if t == nil || t.Kind() != reflect.Ptr {
+		return ""
+	}
+	t = t.Elem()
+	if t.Name() == "" || !strings.HasPrefix(t.PkgPath(), stateTypesPkg) {
+		return ""
+	}
+	pkg := strings.TrimPrefix(t.PkgPath(), stateTypesPkg)
+	pkg = strings.TrimPrefix(pkg, "builtin/")
+	return pkg + "." + t.Name()
+}
+
+func lookupParamType(known map[string]reflect.Type, name string) (reflect.Type, error) {
+	if name == "" {
+		name = "abi.EmptyValue"
+	}
+
+	t, ok := known[name]
+	if !ok {
+		return nil, xerrors.Errorf("unknown type %q, expected a go-state-types type like \"v11/miner.WithdrawBalanceParams\"", name)
+	}
+	if _, ok := reflect.New(t.Elem()).Interface().(cbg.CBORMarshaler); !ok {
+		return nil, xerrors.Errorf("type %q can't be cbor encoded", name)
+	}
+	return t, nil
+}
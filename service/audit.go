@@ -0,0 +1,347 @@
+package service
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/filecoin-project/lotus/lib/tablewriter"
+)
+
+const (
+	auditDir      = "audit"
+	auditLogName  = "audit.log"
+	auditHeadName = "head"
+)
+
+// AuditLog is an append only, hash chained record of every signature made by
+// the local wallet. Each entry commits to the hash of the previous one and the
+// hash of the last entry is kept in a separate head file, so edited, removed
+// or truncated entries are detected by Verify. The chain isn't keyed: it
+// detects corruption and partial edits, not someone able to write tool_path
+// rewriting log and head together. Keep the head printed by 'audit verify'
+// elsewhere to anchor the log.
+type AuditLog struct {
+	path string
+	head string
+}
+
+type AuditEntry struct {
+	Seq        uint64      `json:"seq"`
+	Time       time.Time   `json:"time"`
+	Signer     string      `json:"signer"`
+	Type       api.MsgType `json:"type"`
+	Digest     string      `json:"digest"`
+	MessageCid string      `json:"message_cid,omitempty"`
+	To         string      `json:"to,omitempty"`
+	Value      string      `json:"value,omitempty"`
+	Method     *uint64     `json:"method,omitempty"`
+	Signature  string      `json:"signature"`
+	Prev       string      `json:"prev"`
+	Hash       string      `json:"hash"`
+}
+
+type auditHead struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+func OpenAuditLog() (*AuditLog, error) {
+	dir, err := ToolDataDir(auditDir)
+	if err != nil {
+		return nil, xerrors.Errorf("opening audit log: %w", err)
+	}
+	return &AuditLog{
+		path: filepath.Join(dir, auditLogName),
+		head: filepath.Join(dir, auditHeadName),
+	}, nil
+}
+
+// lock keeps other processes from appending to the log, it must be held from
+// reading the head until the new head is written
+func (l *AuditLog) lock() (io.Closer, error) {
+	return lockDir(filepath.Dir(l.path))
+}
+
+// Append records a signature. msg is the decoded chain message for
+// api.MTChainMsg signatures and nil otherwise.
+func (l *AuditLog) Append(signer address.Address, toSign []byte, meta api.MsgMeta, msg *types.Message, sig *crypto.Signature) error {
+	head, err := l.readHead()
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256(toSign)
+	e := AuditEntry{
+		Seq:       head.Seq + 1,
+		Time:      time.Now().UTC(),
+		Signer:    signer.String(),
+		Type:      meta.Type,
+		Digest:    hex.EncodeToString(digest[:]),
+		Signature: hex.EncodeToString(append([]byte{byte(sig.Type)}, sig.Data...)),
+		Prev:      head.Hash,
+	}
+	if msg != nil {
+		method := uint64(msg.Method)
+		e.MessageCid = msg.Cid().String()
+		e.To = msg.To.String()
+		e.Value = msg.Value.String()
+		e.Method = &method
+	}
+	e.Hash = e.computeHash()
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return xerrors.Errorf("opening audit log: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close() //nolint:errcheck
+		return xerrors.Errorf("writing audit log: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close() //nolint:errcheck
+		return xerrors.Errorf("syncing audit log: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(auditHead{Seq: e.Seq, Hash: e.Hash})
+	if err != nil {
+		return err
+	}
+	return replaceFile(l.head, data)
+}
+
+// Verify checks the whole chain and returns its entries
+func (l *AuditLog) Verify() ([]AuditEntry, error) {
+	// a signature being appended would leave log and head out of step
+	lk, err := l.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer lk.Close() //nolint:errcheck
+
+	head, err := l.readHead()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := l.read()
+	if err != nil {
+		return nil, err
+	}
+
+	prev := auditHead{}
+	for _, e := range entries {
+		if e.Seq != prev.Seq+1 {
+			return nil, xerrors.Errorf("entry %d follows entry %d, entries were removed or reordered", e.Seq, prev.Seq)
+		}
+		if e.Prev != prev.Hash {
+			return nil, xerrors.Errorf("entry %d does not link to the previous entry", e.Seq)
+		}
+		if e.Hash != e.computeHash() {
+			return nil, xerrors.Errorf("entry %d was modified", e.Seq)
+		}
+		prev = auditHead{Seq: e.Seq, Hash: e.Hash}
+	}
+
+	if prev != head {
+		return nil, xerrors.Errorf("log ends at entry %d but %d entries were recorded, the log was truncated", prev.Seq, head.Seq)
+	}
+	return entries, nil
+}
+
+func (l *AuditLog) read() ([]AuditEntry, error) {
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, xerrors.Errorf("opening audit log: %w", err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	var entries []AuditEntry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		var e AuditEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, xerrors.Errorf("audit log line %d is corrupted: %w", line, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, xerrors.Errorf("reading audit log: %w", err)
+	}
+	return entries, nil
+}
+
+func (l *AuditLog) readHead() (auditHead, error) {
+	var head auditHead
+
+	data, err := os.ReadFile(l.head)
+	if os.IsNotExist(err) {
+		return head, nil
+	} else if err != nil {
+		return head, xerrors.Errorf("reading audit log head: %w", err)
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return head, xerrors.Errorf("decoding audit log head: %w", err)
+	}
+	return head, nil
+}
+
+func (e AuditEntry) computeHash() string {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		// only plain strings and numbers, can't fail
+		panic(err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+var AuditCmd = &cli.Command{
+	Name:  "audit",
+	Usage: "Inspect the log of signatures made by the local wallet",
+	Subcommands: []*cli.Command{
+		auditVerify,
+		auditList,
+	},
+}
+
+var auditVerify = &cli.Command{
+	Name:  "verify",
+	Usage: "Check the audit log for edited, removed or truncated entries",
+	Description: `The hash chain is not keyed, it detects corruption and partial edits but not
+   a rewrite of the whole log together with its head. Compare the printed head
+   with a copy kept outside of tool_path to detect that.`,
+	Action: func(cctx *cli.Context) error {
+		l, err := OpenAuditLog()
+		if err != nil {
+			return err
+		}
+
+		entries, err := l.Verify()
+		if err != nil {
+			return xerrors.Errorf("audit log verification failed: %w", err)
+		}
+
+		afmt := lcli.NewAppFmt(cctx.App)
+		if len(entries) == 0 {
+			afmt.Println("audit log is empty")
+			return nil
+		}
+		afmt.Printf("%d entries verified, head %s\n", len(entries), entries[len(entries)-1].Hash)
+		return nil
+	},
+}
+
+var auditList = &cli.Command{
+	Name:  "list",
+	Usage: "List audit log entries",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "address",
+			Usage: "only list signatures by this address",
+		},
+		&cli.TimestampFlag{
+			Name:   "from",
+			Usage:  "only list signatures made at or after this date (YYYY-MM-DD)",
+			Layout: "2006-01-02",
+		},
+		&cli.TimestampFlag{
+			Name:   "to",
+			Usage:  "only list signatures made before the end of this date (YYYY-MM-DD)",
+			Layout: "2006-01-02",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		l, err := OpenAuditLog()
+		if err != nil {
+			return err
+		}
+
+		entries, err := l.Verify()
+		if err != nil {
+			log.Errorf("audit log verification failed, listing anyway: %s", err)
+			if entries, err = l.read(); err != nil {
+				return err
+			}
+		}
+
+		var filter address.Address
+		if a := cctx.String("address"); a != "" {
+			if filter, err = address.NewFromString(a); err != nil {
+				return err
+			}
+		}
+		from := cctx.Timestamp("from")
+		to := cctx.Timestamp("to")
+
+		tw := tablewriter.New(
+			tablewriter.Col("Seq"),
+			tablewriter.Col("Time"),
+			tablewriter.Col("Signer"),
+			tablewriter.Col("Type"),
+			tablewriter.Col("Message"),
+			tablewriter.Col("To"),
+			tablewriter.Col("Value"),
+			tablewriter.Col("Method"))
+
+		for _, e := range entries {
+			if filter != address.Undef {
+				signer, err := address.NewFromString(e.Signer)
+				if err != nil || signer != filter {
+					continue
+				}
+			}
+			if from != nil && e.Time.Before(*from) {
+				continue
+			}
+			if to != nil && !e.Time.Before(to.AddDate(0, 0, 1)) {
+				continue
+			}
+
+			row := map[string]interface{}{
+				"Seq":     e.Seq,
+				"Time":    e.Time.Local().Format(time.RFC3339),
+				"Signer":  e.Signer,
+				"Type":    e.Type,
+				"Message": e.MessageCid,
+				"To":      e.To,
+			}
+			if e.Value != "" {
+				if v, err := types.BigFromString(e.Value); err == nil {
+					row["Value"] = types.FIL(v)
+				}
+			}
+			if e.Method != nil {
+				row["Method"] = *e.Method
+			}
+			tw.Write(row)
+		}
+
+		return tw.Flush(cctx.App.Writer)
+	},
+}
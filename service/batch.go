@@ -0,0 +1,479 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/filecoin-project/lotus/lib/tablewriter"
+)
+
+var sendBatchCmd = &cli.Command{
+	Name:      "batch",
+	Usage:     "Send funds to many addresses listed in a CSV or JSON manifest",
+	ArgsUsage: "<manifest.csv|manifest.json>",
+	Description: `CSV manifests hold 'to,amount[,method,params]' rows, a header row starting
+   with 'to' is skipped. JSON manifests hold an array of objects with the same
+   fields. Every pushed row is journaled under tool_path per manifest path,
+   running the manifest again after an interrupted run skips rows which were
+   already pushed. Rows are identified by recipient, amount, method and params,
+   so fixing or adding rows in the manifest never pays the other rows again.
+   Once every row is pushed the journal is archived, the next run of the same
+   manifest sends all rows again.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "from",
+			Usage: "optionally specify the account to send funds from",
+		},
+		&cli.BoolFlag{
+			Name:  "yes",
+			Usage: "do not ask for confirmation before sending",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+
+		data, err := os.ReadFile(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		rows, err := parseBatchManifest(cctx.Args().First(), data)
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return xerrors.Errorf("manifest has no rows")
+		}
+
+		journal, err := openBatchJournal(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		srv, err := NewLotusService(cctx)
+		if err != nil {
+			return err
+		}
+		defer srv.Close() //nolint:errcheck
+
+		ctx := lcli.ReqContext(cctx)
+		afmt := lcli.NewAppFmt(cctx.App)
+
+		var from address.Address
+		if f := cctx.String("from"); f != "" {
+			from, err = address.NewFromString(f)
+		} else {
+			from, err = srv.DefaultFrom(ctx)
+		}
+		if err != nil {
+			return err
+		}
+
+		total := big.Zero()
+		fees := big.Zero()
+		pending := 0
+		for _, r := range rows {
+			e := journal.Rows[r.key]
+			if e != nil && e.Pushed {
+				continue
+			}
+			total = big.Add(total, r.amount)
+			pending++
+
+			if e != nil && e.Message != nil {
+				fees = big.Add(fees, e.Message.Message.RequiredFunds())
+				continue
+			}
+			fee, err := srv.batchRowFee(ctx, from, r)
+			if err != nil {
+				return xerrors.Errorf("row %d: %w", r.line, err)
+			}
+			fees = big.Add(fees, fee)
+		}
+
+		act, err := srv.api.StateGetActor(ctx, from, types.EmptyTSK)
+		if err != nil {
+			return xerrors.Errorf("getting sender actor: %w", err)
+		}
+		if need := big.Add(total, fees); act.Balance.LessThan(need) {
+			return xerrors.Errorf("sender %s balance %s is lower than the %s left to send plus up to %s gas",
+				from, types.FIL(act.Balance), types.FIL(total), types.FIL(fees))
+		}
+
+		if skipped := len(rows) - pending; skipped > 0 {
+			afmt.Printf("WARNING: %d of %d rows skipped, already pushed by a previous run started %s (journal %s)\n",
+				skipped, len(rows), journal.Started.Local().Format(time.RFC3339), journal.path)
+		}
+		afmt.Printf("Sending %s plus up to %s gas to %d addresses from %s\n",
+			types.FIL(total), types.FIL(fees), pending, from)
+		if pending > 0 && !cctx.Bool("yes") && !askUser(cctx.App.Writer, "Proceed? [yes/No]: ", false) {
+			return ErrAbortedByUser
+		}
+
+		var nonce *uint64
+		for _, r := range rows {
+			e := journal.Rows[r.key]
+			if e != nil && e.Pushed {
+				continue
+			}
+
+			if e != nil && e.Message != nil {
+				// signed before a crash, push the very same message again
+				// so the row can never be paid twice
+				found, err := srv.batchMessageKnown(ctx, e.Cid)
+				if err != nil {
+					return xerrors.Errorf("row %d: %w", r.line, err)
+				}
+				if !found {
+					if err := srv.PushSigned(ctx, e.Message); err != nil {
+						return xerrors.Errorf("row %d: pushing message %s: %w", r.line, e.Cid, err)
+					}
+				}
+				e.Pushed = true
+				if err := journal.save(); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if nonce == nil {
+				n, err := srv.NextNonce(ctx, from)
+				if err != nil {
+					return err
+				}
+				nonce = &n
+			}
+
+			sm, err := srv.signBatchRow(ctx, from, *nonce, r)
+			if err != nil {
+				return xerrors.Errorf("row %d: %w", r.line, err)
+			}
+
+			e = &batchJournalEntry{
+				To:      r.to.String(),
+				Amount:  types.FIL(r.amount).String(),
+				Cid:     sm.Cid(),
+				Message: sm,
+			}
+			journal.Rows[r.key] = e
+			if err := journal.save(); err != nil {
+				return err
+			}
+
+			if err := srv.PushSigned(ctx, sm); err != nil {
+				return xerrors.Errorf("row %d: pushing message: %w", r.line, err)
+			}
+			e.Pushed = true
+			if err := journal.save(); err != nil {
+				return err
+			}
+			*nonce++
+		}
+
+		tw := tablewriter.New(
+			tablewriter.Col("Row"),
+			tablewriter.Col("To"),
+			tablewriter.Col("Amount"),
+			tablewriter.Col("Message"))
+		for _, r := range rows {
+			e := journal.Rows[r.key]
+			tw.Write(map[string]interface{}{
+				"Row":     r.line,
+				"To":      e.To,
+				"Amount":  e.Amount,
+				"Message": e.Cid,
+			})
+		}
+		if err := tw.Flush(cctx.App.Writer); err != nil {
+			return err
+		}
+
+		// every row is pushed, a later run of the manifest is a new batch
+		archived, err := journal.archive()
+		if err != nil {
+			return err
+		}
+		afmt.Printf("Batch complete, journal archived to %s\n", archived)
+		return nil
+	},
+}
+
+func (s *LotusService) signBatchRow(ctx context.Context, from address.Address, nonce uint64, r batchRow) (*types.SignedMessage, error) {
+	proto, err := s.batchRowPrototype(ctx, from, &nonce, r)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := s.FillMessage(ctx, proto)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.WalletSignMessage(ctx, from, msg)
+}
+
+// batchRowFee estimates the maximum gas fee of sending a row
+func (s *LotusService) batchRowFee(ctx context.Context, from address.Address, r batchRow) (abi.TokenAmount, error) {
+	proto, err := s.batchRowPrototype(ctx, from, nil, r)
+	if err != nil {
+		return big.Zero(), err
+	}
+
+	msg, err := s.api.GasEstimateMessageGas(ctx, &proto.Message, nil, types.EmptyTSK)
+	if err != nil {
+		return big.Zero(), xerrors.Errorf("estimating gas: %w", err)
+	}
+	return msg.RequiredFunds(), nil
+}
+
+func (s *LotusService) batchRowPrototype(ctx context.Context, from address.Address, nonce *uint64, r batchRow) (*api.MessagePrototype, error) {
+	params := lcli.SendParams{
+		From:   from,
+		To:     r.to,
+		Val:    r.amount,
+		Method: r.method,
+		Nonce:  nonce,
+	}
+	if r.params != "" {
+		decparams, err := s.DecodeTypedParamsFromJSON(ctx, r.to, r.method, r.params)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to decode json params: %w", err)
+		}
+		params.Params = decparams
+	}
+
+	proto, err := s.MessageForSend(ctx, params)
+	if err != nil {
+		return nil, xerrors.Errorf("creating message prototype: %w", err)
+	}
+	return proto, nil
+}
+
+// batchMessageKnown reports whether a journaled message already is on chain
+// or waiting in the mpool, pushing it again would fail in the latter case
+func (s *LotusService) batchMessageKnown(ctx context.Context, c cid.Cid) (bool, error) {
+	found, err := s.api.StateSearchMsg(ctx, types.EmptyTSK, c, api.LookbackNoLimit, true)
+	if err != nil {
+		return false, xerrors.Errorf("searching message %s: %w", c, err)
+	}
+	if found != nil {
+		return true, nil
+	}
+
+	pending, err := s.api.MpoolPending(ctx, types.EmptyTSK)
+	if err != nil {
+		return false, xerrors.Errorf("listing pending messages: %w", err)
+	}
+	for _, sm := range pending {
+		if sm.Cid() == c {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type batchRow struct {
+	line int
+	// key identifies the row in the journal independent of its position
+	key string
+
+	to     address.Address
+	amount abi.TokenAmount
+	method abi.MethodNum
+	params string
+}
+
+type batchManifestEntry struct {
+	To     string          `json:"to"`
+	Amount string          `json:"amount"`
+	Method uint64          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// parseBatchManifest parses and validates every row of the manifest, all
+// invalid rows are reported at once
+func parseBatchManifest(name string, data []byte) ([]batchRow, error) {
+	var entries []batchManifestEntry
+	var lines []int
+
+	if strings.EqualFold(filepath.Ext(name), ".json") {
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, xerrors.Errorf("decoding json manifest: %w", err)
+		}
+		for i := range entries {
+			lines = append(lines, i+1)
+		}
+	} else {
+		r := csv.NewReader(strings.NewReader(string(data)))
+		r.FieldsPerRecord = -1
+		r.TrimLeadingSpace = true
+		for {
+			rec, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, xerrors.Errorf("reading csv manifest: %w", err)
+			}
+			line, _ := r.FieldPos(0)
+			if len(entries) == 0 && strings.EqualFold(strings.TrimSpace(rec[0]), "to") {
+				continue
+			}
+			if len(rec) < 2 || len(rec) > 4 {
+				return nil, xerrors.Errorf("line %d: expected 'to,amount[,method,params]', got %d fields", line, len(rec))
+			}
+
+			e := batchManifestEntry{To: rec[0], Amount: rec[1], Method: uint64(builtin.MethodSend)}
+			if len(rec) > 2 && strings.TrimSpace(rec[2]) != "" {
+				m, err := strconv.ParseUint(strings.TrimSpace(rec[2]), 10, 64)
+				if err != nil {
+					return nil, xerrors.Errorf("line %d: parsing method: %w", line, err)
+				}
+				e.Method = m
+			}
+			if len(rec) > 3 {
+				e.Params = json.RawMessage(rec[3])
+			}
+			entries = append(entries, e)
+			lines = append(lines, line)
+		}
+	}
+
+	var rows []batchRow
+	var errs []string
+	for i, e := range entries {
+		to, err := address.NewFromString(strings.TrimSpace(e.To))
+		if err == nil && to == address.Undef {
+			err = xerrors.Errorf("empty address")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("row %d: invalid address %q: %s", lines[i], e.To, err))
+			continue
+		}
+		val, err := types.ParseFIL(strings.TrimSpace(e.Amount))
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("row %d: invalid amount %q: %s", lines[i], e.Amount, err))
+			continue
+		}
+
+		rows = append(rows, batchRow{
+			line:   lines[i],
+			to:     to,
+			amount: abi.TokenAmount(val),
+			method: abi.MethodNum(e.Method),
+			params: strings.TrimSpace(string(e.Params)),
+		})
+	}
+	if len(errs) > 0 {
+		return nil, xerrors.Errorf("invalid manifest:\n%s", strings.Join(errs, "\n"))
+	}
+
+	// identical rows are told apart by their occurrence
+	seen := map[string]int{}
+	for i := range rows {
+		r := &rows[i]
+		id := fmt.Sprintf("%x/%s/%d/%s", r.to.Bytes(), r.amount, r.method, r.params)
+		r.key = fmt.Sprintf("%s#%d", id, seen[id])
+		seen[id]++
+	}
+
+	return rows, nil
+}
+
+// batchJournal records the signed message of every manifest row. It is kept
+// per manifest path and keyed by batchRow.key, so a rerun resumes even after
+// the manifest was edited.
+type batchJournal struct {
+	path string
+
+	Started time.Time                     `json:"started"`
+	Rows    map[string]*batchJournalEntry `json:"rows"`
+}
+
+type batchJournalEntry struct {
+	To      string               `json:"to"`
+	Amount  string               `json:"amount"`
+	Cid     cid.Cid              `json:"cid"`
+	Pushed  bool                 `json:"pushed"`
+	Message *types.SignedMessage `json:"message"`
+}
+
+func openBatchJournal(manifestPath string) (*batchJournal, error) {
+	dir, err := ToolDataDir("batches")
+	if err != nil {
+		return nil, err
+	}
+
+	abs, err := filepath.Abs(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	j := &batchJournal{
+		path:    filepath.Join(dir, hex.EncodeToString(sum[:16])+".json"),
+		Started: time.Now().UTC(),
+		Rows:    map[string]*batchJournalEntry{},
+	}
+
+	data, err := os.ReadFile(j.path)
+	if os.IsNotExist(err) {
+		return j, nil
+	} else if err != nil {
+		return nil, xerrors.Errorf("reading batch journal: %w", err)
+	}
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, xerrors.Errorf("decoding batch journal %s: %w", j.path, err)
+	}
+	log.Infof("resuming batch from journal %s", j.path)
+	return j, nil
+}
+
+// archive moves the journal of a completed batch out of the way, keeping it
+// for reference under batches/done
+func (j *batchJournal) archive() (string, error) {
+	dir := filepath.Join(filepath.Dir(j.path), "done")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	name := strings.TrimSuffix(filepath.Base(j.path), ".json")
+	dst := filepath.Join(dir, fmt.Sprintf("%s-%s.json", name, j.Started.Format("20060102T150405Z")))
+	if err := os.Rename(j.path, dst); err != nil {
+		return "", xerrors.Errorf("archiving batch journal: %w", err)
+	}
+	return dst, nil
+}
+
+func (j *batchJournal) save() error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(j.path, data); err != nil {
+		return xerrors.Errorf("writing batch journal: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+type testBatchRow struct {
+	line   int
+	to     string
+	amount string
+	method abi.MethodNum
+	params string
+}
+
+func TestParseBatchManifest(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		file string
+		data string
+		rows []testBatchRow
+		errs []string
+	}{{
+		name: "csv with header",
+		file: "payouts.csv",
+		data: "to,amount\nf01000,1\nf01001, 0.5\n",
+		rows: []testBatchRow{
+			{line: 2, to: "f01000", amount: "1"},
+			{line: 3, to: "f01001", amount: "0.5"},
+		},
+	}, {
+		name: "csv without header",
+		file: "payouts.csv",
+		data: "f01000,1\n",
+		rows: []testBatchRow{{line: 1, to: "f01000", amount: "1"}},
+	}, {
+		name: "csv with method and params",
+		file: "payouts.csv",
+		data: "TO,amount,method,params\nf01000,0,2,\"{\"\"a\"\":1}\"\nf01001,1,,\n",
+		rows: []testBatchRow{
+			{line: 2, to: "f01000", amount: "0", method: 2, params: `{"a":1}`},
+			{line: 3, to: "f01001", amount: "1"},
+		},
+	}, {
+		name: "header only skipped on the first row",
+		file: "payouts.csv",
+		data: "f01000,1\nto,amount\n",
+		errs: []string{`row 2: invalid address "to"`},
+	}, {
+		name: "every invalid row reported",
+		file: "payouts.csv",
+		data: "to,amount\nf01000,1\nnot-an-address,1\nf01001,lots\n",
+		errs: []string{`row 3: invalid address "not-an-address"`, `row 4: invalid amount "lots"`},
+	}, {
+		name: "wrong field count",
+		file: "payouts.csv",
+		data: "f01000\n",
+		errs: []string{"line 1: expected 'to,amount[,method,params]', got 1 fields"},
+	}, {
+		name: "bad method",
+		file: "payouts.csv",
+		data: "f01000,1,send\n",
+		errs: []string{"line 1: parsing method"},
+	}, {
+		name: "json",
+		file: "payouts.JSON",
+		data: `[{"to":"f01000","amount":"1"},{"to":"f01001","amount":"0","method":2,"params":{"a":1}}]`,
+		rows: []testBatchRow{
+			{line: 1, to: "f01000", amount: "1"},
+			{line: 2, to: "f01001", amount: "0", method: 2, params: `{"a":1}`},
+		},
+	}, {
+		name: "json invalid rows",
+		file: "payouts.json",
+		data: `[{"to":"f01000","amount":"x"},{"to":"","amount":"1"}]`,
+		errs: []string{`row 1: invalid amount "x"`, `row 2: invalid address ""`},
+	}, {
+		name: "json not an array",
+		file: "payouts.json",
+		data: `{"to":"f01000","amount":"1"}`,
+		errs: []string{"decoding json manifest"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			rows, err := parseBatchManifest(tc.file, []byte(tc.data))
+			if len(tc.errs) > 0 {
+				if err == nil {
+					t.Fatalf("expected error, got %d rows", len(rows))
+				}
+				for _, e := range tc.errs {
+					if !strings.Contains(err.Error(), e) {
+						t.Errorf("error %q doesn't mention %q", err, e)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(rows) != len(tc.rows) {
+				t.Fatalf("got %d rows, want %d", len(rows), len(tc.rows))
+			}
+			for i, want := range tc.rows {
+				got := rows[i]
+				amount, err := types.ParseFIL(want.amount)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.line != want.line || got.to.String() != want.to || !got.amount.Equals(abi.TokenAmount(amount)) ||
+					got.method != want.method || got.params != want.params {
+					t.Errorf("row %d: got {%d %s %s %d %s}, want %+v", i, got.line, got.to, types.FIL(got.amount), got.method, got.params, want)
+				}
+			}
+		})
+	}
+}
+
+func batchKeys(t *testing.T, data string) []string {
+	rows, err := parseBatchManifest("payouts.csv", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, r := range rows {
+		keys = append(keys, r.key)
+	}
+	return keys
+}
+
+func TestBatchRowKeys(t *testing.T) {
+	keys := batchKeys(t, "to,amount\nf01000,1\nf01001,1\nf01000,1\nf01000,2\n")
+	if keys[0] == keys[2] {
+		t.Fatal("identical rows share a journal key, one of them would never be paid")
+	}
+	seen := map[string]bool{}
+	for _, k := range keys {
+		if seen[k] {
+			t.Fatalf("duplicate key %s", k)
+		}
+		seen[k] = true
+	}
+
+	// keys don't depend on line numbers or formatting, adding a row or a
+	// header keeps every other row journaled as before
+	edited := batchKeys(t, "f01002,3\nf01000, 1.0\nf01001,1\nf01000,1\nf01000,2\n")
+	for i, k := range keys {
+		if edited[i+1] != k {
+			t.Errorf("row %d: key changed from %s to %s", i, k, edited[i+1])
+		}
+	}
+
+	// only occurrences of the very same row are counted
+	if k := batchKeys(t, "f01000,2\n"); k[0] != keys[3] {
+		t.Errorf("key of a unique row depends on other rows: %s != %s", k[0], keys[3])
+	}
+
+	// the same recipient with another amount, method or params is another row
+	other := batchKeys(t, "f01000,1,2\nf01000,1,0,{}\n")
+	for _, k := range other {
+		if seen[k] {
+			t.Errorf("key %s collides with a different row", k)
+		}
+	}
+}
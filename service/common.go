@@ -2,6 +2,16 @@ package service
 
 import (
 	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	fslock "github.com/ipfs/go-fs-lock"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-jsonrpc"
 	"github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/api/client"
@@ -10,12 +20,116 @@ import (
 )
 
 func GetFullNodeApi(ctx context.Context) (api.FullNode, jsonrpc.ClientCloser, error) {
-	ainfo := cliutil.ParseApiInfo(conf.GetConfig().Repo.FullNodeApi)
+	cfg, err := conf.GetConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ainfo := cliutil.ParseApiInfo(cfg.Repo.FullNodeApi)
 	addr, err := ainfo.DialArgs("v1")
 	if err != nil {
 		return nil, nil, err
 	}
 	log.Infof("using raw API endpoint: %s", addr)
 
-	return client.NewFullNodeRPCV1(ctx, addr, ainfo.AuthHeader())
+	node, closer, err := client.NewFullNodeRPCV1(ctx, addr, ainfo.AuthHeader())
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkNodeNetwork(ctx, node); err != nil {
+		closer()
+		return nil, nil, err
+	}
+	return node, closer, nil
+}
+
+// checkNodeNetwork makes sure the full node of a network profile serves the
+// profile's address network, mainnet is the only network using f addresses.
+// Without a profile the address network follows the node.
+func checkNodeNetwork(ctx context.Context, node api.FullNode) error {
+	cfg, err := conf.GetConfig()
+	if err != nil {
+		return err
+	}
+
+	name, err := node.StateNetworkName(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting network name of the full node: %w", err)
+	}
+
+	mainnet := name == "mainnet"
+	if cfg.Network == "" {
+		if !mainnet {
+			address.CurrentNetwork = address.Testnet
+		}
+		return nil
+	}
+	if mainnet != (address.CurrentNetwork == address.Mainnet) {
+		return xerrors.Errorf("full node %s is on network %s, which doesn't match the %s network profile", cfg.Repo.FullNodeApi, name, cfg.Network)
+	}
+	return nil
+}
+
+// ToolDataDir returns the named sub directory of tool_path, creating it on
+// first use. The keystore ignores sub directories so tool state can live
+// alongside the keys.
+func ToolDataDir(name string) (string, error) {
+	cfg, err := conf.GetConfig()
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(cfg.Repo.ToolPath, name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// replaceFile atomically replaces the content of path, the temporary file is
+// a dot file so it never shows up in List
+func replaceFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close() //nolint:errcheck
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close() //nolint:errcheck
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close() //nolint:errcheck
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+const (
+	dirLockName    = ".lock"
+	dirLockTimeout = time.Minute
+)
+
+// lockDir takes an exclusive lock on dir shared by every process using the
+// same tool_path, waiting while another one holds it
+func lockDir(dir string) (io.Closer, error) {
+	deadline := time.Now().Add(dirLockTimeout)
+	for {
+		lk, err := fslock.Lock(dir, dirLockName)
+		if err == nil {
+			return lk, nil
+		}
+		if !errors.As(err, new(fslock.LockedError)) || time.Now().After(deadline) {
+			return nil, xerrors.Errorf("locking %s: %w", dir, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
 }
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// SimulateMessage fills nonce and gas for the prototype and executes it on top
+// of the current head without signing or pushing anything
+func (s *LotusService) SimulateMessage(ctx context.Context, prototype *api.MessagePrototype) (*types.Message, *api.InvocResult, error) {
+	msg, err := s.FillMessage(ctx, prototype)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := s.api.StateCall(ctx, msg, types.EmptyTSK)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("calling message: %w", err)
+	}
+	if res.MsgRct == nil {
+		return nil, nil, xerrors.Errorf("calling message: no receipt: %s", res.Error)
+	}
+	return msg, res, nil
+}
+
+// DryRun simulates the prototype and prints the expected outcome. A failing
+// execution is returned as an error exiting with ExitMessageFailed.
+func DryRun(ctx context.Context, w io.Writer, srv *LotusService, prototype *api.MessagePrototype) error {
+	msg, res, err := srv.SimulateMessage(ctx, prototype)
+	if err != nil {
+		return err
+	}
+
+	act, err := srv.api.StateGetActor(ctx, msg.From, types.EmptyTSK)
+	if err != nil {
+		return xerrors.Errorf("getting sender actor: %w", err)
+	}
+
+	maxFee := big.Mul(msg.GasFeeCap, big.NewInt(msg.GasLimit))
+	cost := res.GasCost.TotalCost
+	if cost.Nil() || cost.IsZero() {
+		cost = maxFee
+	}
+
+	printMessageSummary(w, msg)
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Exit Code:      %d (%s)\n", res.MsgRct.ExitCode, res.MsgRct.ExitCode)
+	if res.Error != "" {
+		fmt.Fprintf(w, "Error:          %s\n", res.Error)
+	}
+	fmt.Fprintf(w, "Gas Used:       %d\n", res.MsgRct.GasUsed)
+	fmt.Fprintf(w, "Estimated Cost: %s\n", types.FIL(cost))
+	fmt.Fprintf(w, "Balance:        %s\n", types.FIL(act.Balance))
+	fmt.Fprintf(w, "Balance After:  %s (at most %s spent on gas)\n",
+		types.FIL(big.Sub(big.Sub(act.Balance, msg.Value), cost)), types.FIL(maxFee))
+
+	if len(res.MsgRct.Return) > 0 {
+		ret, err := srv.DecodeReturnToJSON(ctx, msg.To, msg.Method, res.MsgRct.Return)
+		if err != nil {
+			fmt.Fprintf(w, "Return:         %x (%s)\n", res.MsgRct.Return, err)
+		} else {
+			fmt.Fprintf(w, "Return:         %s\n", ret)
+		}
+	}
+
+	if res.MsgRct.ExitCode.IsError() {
+		return cli.Exit(fmt.Sprintf("message would fail with exit code %d (%s)",
+			res.MsgRct.ExitCode, res.MsgRct.ExitCode), ExitMessageFailed)
+	}
+	return nil
+}
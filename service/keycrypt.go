@@ -0,0 +1,186 @@
+package service
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+const (
+	sealedKeyVersion = 1
+
+	// scrypt parameters, same cost as the "standard" ethereum keystore
+	scryptN      = 1 << 18
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = chacha20poly1305.KeySize
+	scryptSalt   = 32
+
+	EnvPassphrase     = "LOTUS_TOOLS_PASSPHRASE"
+	EnvPassphraseFile = "LOTUS_TOOLS_PASSPHRASE_FILE"
+)
+
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted key file")
+
+// PassphraseFunc returns the keystore passphrase. When confirm is set the
+// passphrase is about to be used for the first time and interactive sources
+// should ask for it twice.
+type PassphraseFunc func(confirm bool) ([]byte, error)
+
+// sealedKey is the on-disk format of an encrypted key file
+type sealedKey struct {
+	Version    int          `json:"version"`
+	KDF        string       `json:"kdf"`
+	KDFParams  scryptParams `json:"kdfparams"`
+	Cipher     string       `json:"cipher"`
+	Nonce      []byte       `json:"nonce"`
+	Ciphertext []byte       `json:"ciphertext"`
+}
+
+type scryptParams struct {
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	Salt []byte `json:"salt"`
+}
+
+// isSealed reports whether data holds an encrypted key rather than a plain
+// json encoded types.KeyInfo
+func isSealed(data []byte) bool {
+	var probe struct {
+		Cipher string `json:"cipher"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return false
+	}
+	return probe.Cipher != ""
+}
+
+// sealKey encrypts plaintext with a key derived from passphrase. The key name
+// is bound as additional data so sealed files can not be swapped around.
+func sealKey(name string, plaintext, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, scryptSalt)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	params := scryptParams{N: scryptN, R: scryptR, P: scryptP, Salt: salt}
+	aead, err := params.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(sealedKey{
+		Version:    sealedKeyVersion,
+		KDF:        "scrypt",
+		KDFParams:  params,
+		Cipher:     "xchacha20-poly1305",
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, []byte(name)),
+	})
+}
+
+// openKey decrypts a key sealed with sealKey
+func openKey(name string, data, passphrase []byte) ([]byte, error) {
+	var sk sealedKey
+	if err := json.Unmarshal(data, &sk); err != nil {
+		return nil, err
+	}
+	if sk.Version != sealedKeyVersion {
+		return nil, fmt.Errorf("unsupported key file version %d", sk.Version)
+	}
+	if sk.KDF != "scrypt" || sk.Cipher != "xchacha20-poly1305" {
+		return nil, fmt.Errorf("unsupported key encryption %s/%s", sk.KDF, sk.Cipher)
+	}
+
+	aead, err := sk.KDFParams.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(sk.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce length %d", len(sk.Nonce))
+	}
+
+	plaintext, err := aead.Open(nil, sk.Nonce, sk.Ciphertext, []byte(name))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plaintext, nil
+}
+
+func (p scryptParams) aead(passphrase []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, p.Salt, p.N, p.R, p.P, scryptKeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("deriving key: %w", err)
+	}
+	return chacha20poly1305.NewX(key)
+}
+
+// NewPassphraseSource returns a PassphraseFunc which reads the passphrase from
+// the LOTUS_TOOLS_PASSPHRASE env, the file named by LOTUS_TOOLS_PASSPHRASE_FILE
+// or passFile, or prompts for it on the terminal, in that order.
+func NewPassphraseSource(passFile string) PassphraseFunc {
+	return func(confirm bool) ([]byte, error) {
+		if pass, ok := os.LookupEnv(EnvPassphrase); ok {
+			return []byte(pass), nil
+		}
+
+		if f := os.Getenv(EnvPassphraseFile); f != "" {
+			passFile = f
+		}
+		if passFile != "" {
+			data, err := os.ReadFile(passFile)
+			if err != nil {
+				return nil, fmt.Errorf("reading passphrase file: %w", err)
+			}
+			return bytes.TrimRight(data, "\r\n"), nil
+		}
+
+		return promptPassphrase(confirm)
+	}
+}
+
+func promptPassphrase(confirm bool) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("keystore is encrypted, set %s or %s", EnvPassphrase, EnvPassphraseFile)
+	}
+
+	fmt.Fprint(os.Stderr, "Enter keystore passphrase: ")
+	pass, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if len(strings.TrimSpace(string(pass))) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+
+	if confirm {
+		fmt.Fprint(os.Stderr, "Repeat passphrase: ")
+		again, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(pass, again) {
+			return nil, errors.New("passphrases do not match")
+		}
+	}
+
+	return pass, nil
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSealOpenKey(t *testing.T) {
+	plaintext := []byte(`{"Type":"secp256k1","PrivateKey":"c2VjcmV0"}`)
+	pass := []byte("correct horse battery staple")
+
+	sealed, err := sealKey("wallet-f1abc", plaintext, pass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isSealed(sealed) {
+		t.Fatal("sealed key not detected as sealed")
+	}
+	if isSealed(plaintext) {
+		t.Fatal("plain key detected as sealed")
+	}
+	if bytes.Contains(sealed, plaintext) {
+		t.Fatal("sealed key contains the plaintext")
+	}
+
+	opened, err := openKey("wallet-f1abc", sealed, pass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Fatalf("opened %q, want %q", opened, plaintext)
+	}
+
+	if _, err := openKey("wallet-f1abc", sealed, []byte("wrong")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("wrong passphrase: expected ErrWrongPassphrase, got %v", err)
+	}
+
+	// the name is bound as additional data, a key file copied over another
+	// one must not open
+	if _, err := openKey("wallet-f1def", sealed, pass); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("other name: expected ErrWrongPassphrase, got %v", err)
+	}
+}
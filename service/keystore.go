@@ -6,18 +6,26 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/wallet"
 	"github.com/whyrusleeping/base32"
 )
 
 type DiskKeyStore struct {
 	path string
+
+	// encrypt makes Put seal new keys, Get opens sealed keys regardless
+	encrypt    bool
+	passSource PassphraseFunc
+	passphrase []byte
 }
 
-func OpenOrInitKeystore(p string) (*DiskKeyStore, error) {
+func OpenOrInitKeystore(p string, encrypt bool, passSource PassphraseFunc) (*DiskKeyStore, error) {
 	if _, err := os.Stat(p); err == nil {
-		return &DiskKeyStore{p}, nil
+		return &DiskKeyStore{path: p, encrypt: encrypt, passSource: passSource}, nil
 	} else if !os.IsNotExist(err) {
 		return nil, err
 	}
@@ -26,9 +34,13 @@ func OpenOrInitKeystore(p string) (*DiskKeyStore, error) {
 		return nil, err
 	}
 
-	return &DiskKeyStore{p}, nil
+	return &DiskKeyStore{path: p, encrypt: encrypt, passSource: passSource}, nil
 }
 
+// keystoreNetworkFile records the network profile of a keystore, dot files
+// are skipped by List
+const keystoreNetworkFile = ".network"
+
 var kstrPermissionMsg = "permissions of key: '%s' are too relaxed, " +
 	"required: 0600, got: %#o"
 
@@ -46,6 +58,11 @@ func (fsr *DiskKeyStore) List() ([]string, error) {
 	}
 	keys := make([]string, 0, len(files))
 	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			// tool state lives in sub directories, base32 names never start
+			// with a dot so those are temporary files
+			continue
+		}
 		if f.Mode()&0077 != 0 {
 			return nil, fmt.Errorf(kstrPermissionMsg, f.Name(), f.Mode())
 		}
@@ -60,6 +77,7 @@ func (fsr *DiskKeyStore) List() ([]string, error) {
 
 // Get gets a key out of keystore and returns types.KeyInfo coresponding to named key
 func (fsr *DiskKeyStore) Get(name string) (types.KeyInfo, error) {
+	name = storedKeyName(name)
 
 	encName := base32.RawStdEncoding.EncodeToString([]byte(name))
 	keyPath := filepath.Join(fsr.path, encName)
@@ -86,6 +104,18 @@ func (fsr *DiskKeyStore) Get(name string) (types.KeyInfo, error) {
 		return types.KeyInfo{}, fmt.Errorf("reading key '%s': %w", name, err)
 	}
 
+	if isSealed(data) {
+		pass, err := fsr.unlock(false)
+		if err != nil {
+			return types.KeyInfo{}, fmt.Errorf("unlocking key '%s': %w", name, err)
+		}
+		data, err = openKey(name, data, pass)
+		if err != nil {
+			fsr.passphrase = nil
+			return types.KeyInfo{}, fmt.Errorf("decrypting key '%s': %w", name, err)
+		}
+	}
+
 	var res types.KeyInfo
 	err = json.Unmarshal(data, &res)
 	if err != nil {
@@ -97,6 +127,7 @@ func (fsr *DiskKeyStore) Get(name string) (types.KeyInfo, error) {
 
 // Put saves key info under given name
 func (fsr *DiskKeyStore) Put(name string, info types.KeyInfo) error {
+	name = storedKeyName(name)
 
 	encName := base32.RawStdEncoding.EncodeToString([]byte(name))
 	keyPath := filepath.Join(fsr.path, encName)
@@ -113,6 +144,13 @@ func (fsr *DiskKeyStore) Put(name string, info types.KeyInfo) error {
 		return fmt.Errorf("encoding key '%s': %w", name, err)
 	}
 
+	if fsr.encrypt {
+		keyData, err = fsr.seal(name, keyData)
+		if err != nil {
+			return fmt.Errorf("encrypting key '%s': %w", name, err)
+		}
+	}
+
 	err = os.WriteFile(keyPath, keyData, 0600)
 	if err != nil {
 		return fmt.Errorf("writing key '%s': %w", name, err)
@@ -121,6 +159,7 @@ func (fsr *DiskKeyStore) Put(name string, info types.KeyInfo) error {
 }
 
 func (fsr *DiskKeyStore) Delete(name string) error {
+	name = storedKeyName(name)
 
 	encName := base32.RawStdEncoding.EncodeToString([]byte(name))
 	keyPath := filepath.Join(fsr.path, encName)
@@ -138,3 +177,182 @@ func (fsr *DiskKeyStore) Delete(name string) error {
 	}
 	return nil
 }
+
+// Encrypt seals every plaintext key in the keystore with the keystore
+// passphrase, returning the number of converted keys.
+func (fsr *DiskKeyStore) Encrypt() (int, error) {
+	names, err := fsr.List()
+	if err != nil {
+		return 0, err
+	}
+
+	converted := 0
+	for _, name := range names {
+		keyPath := fsr.keyPath(name)
+		data, err := os.ReadFile(keyPath)
+		if err != nil {
+			return converted, fmt.Errorf("reading key '%s': %w", name, err)
+		}
+		if isSealed(data) {
+			continue
+		}
+
+		var ki types.KeyInfo
+		if err := json.Unmarshal(data, &ki); err != nil {
+			return converted, fmt.Errorf("decoding key '%s': %w", name, err)
+		}
+
+		sealed, err := fsr.seal(name, data)
+		if err != nil {
+			return converted, fmt.Errorf("encrypting key '%s': %w", name, err)
+		}
+
+		if err := replaceFile(keyPath, sealed); err != nil {
+			return converted, fmt.Errorf("writing key '%s': %w", name, err)
+		}
+		converted++
+	}
+	return converted, nil
+}
+
+// Unlock obtains and verifies the passphrase up front, so long running
+// commands don't prompt for it on first use. It is a no-op for plaintext
+// keystores.
+func (fsr *DiskKeyStore) Unlock() error {
+	if !fsr.encrypt {
+		_, data, err := fsr.anySealed()
+		if err != nil || data == nil {
+			return err
+		}
+	}
+	_, err := fsr.unlock(true)
+	return err
+}
+
+// storedKeyName maps wallet key names to their mainnet address form, keys are
+// stored under it whichever address network is selected
+func storedKeyName(name string) string {
+	testnet := wallet.KNamePrefix + address.TestnetPrefix
+	if strings.HasPrefix(name, testnet) {
+		return wallet.KNamePrefix + address.MainnetPrefix + strings.TrimPrefix(name, testnet)
+	}
+	return name
+}
+
+// checkNetwork ties the keystore to a network profile, so it is never used
+// with another one. An empty keystore is assigned to the profile opening it,
+// one already holding keys has to be assigned with 'wallet set-network'.
+func (fsr *DiskKeyStore) checkNetwork(network string) error {
+	marked, err := fsr.network()
+	if err != nil {
+		return err
+	}
+	if marked == "" {
+		if network == "" {
+			return nil
+		}
+		keys, err := fsr.List()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			return fmt.Errorf("keystore %s holds keys but belongs to no network yet, assign it with 'wallet set-network' if its keys are meant for %s", fsr.path, network)
+		}
+		return fsr.SetNetwork(network)
+	}
+
+	if network == "" {
+		return fmt.Errorf("keystore %s belongs to network %s, select it with --network", fsr.path, marked)
+	}
+	if marked != network {
+		return fmt.Errorf("keystore %s belongs to network %s, not %s", fsr.path, marked, network)
+	}
+	return nil
+}
+
+// network returns the network profile recorded for the keystore, empty if
+// there is none
+func (fsr *DiskKeyStore) network() (string, error) {
+	data, err := os.ReadFile(filepath.Join(fsr.path, keystoreNetworkFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("reading keystore network: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// SetNetwork records the network profile of the keystore
+func (fsr *DiskKeyStore) SetNetwork(network string) error {
+	return replaceFile(filepath.Join(fsr.path, keystoreNetworkFile), []byte(network+"\n"))
+}
+
+func (fsr *DiskKeyStore) keyPath(name string) string {
+	return filepath.Join(fsr.path, base32.RawStdEncoding.EncodeToString([]byte(name)))
+}
+
+func (fsr *DiskKeyStore) seal(name string, keyData []byte) ([]byte, error) {
+	pass, err := fsr.unlock(true)
+	if err != nil {
+		return nil, err
+	}
+	return sealKey(name, keyData, pass)
+}
+
+// unlock returns the keystore passphrase. Before a passphrase is used for
+// sealing it is checked against an existing sealed key so that a typo can not
+// leave the keystore encrypted under two different passphrases.
+func (fsr *DiskKeyStore) unlock(sealing bool) ([]byte, error) {
+	if fsr.passphrase != nil {
+		return fsr.passphrase, nil
+	}
+	if fsr.passSource == nil {
+		return nil, fmt.Errorf("no passphrase source configured")
+	}
+
+	if !sealing {
+		pass, err := fsr.passSource(false)
+		if err != nil {
+			return nil, err
+		}
+		fsr.passphrase = pass
+		return pass, nil
+	}
+
+	name, data, err := fsr.anySealed()
+	if err != nil {
+		return nil, err
+	}
+
+	pass, err := fsr.passSource(data == nil)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		if _, err := openKey(name, data, pass); err != nil {
+			return nil, err
+		}
+	}
+
+	fsr.passphrase = pass
+	return pass, nil
+}
+
+// anySealed returns the name and content of some encrypted key in the
+// keystore, or nil data if the keystore holds none
+func (fsr *DiskKeyStore) anySealed() (string, []byte, error) {
+	names, err := fsr.List()
+	if err != nil {
+		return "", nil, err
+	}
+	for _, name := range names {
+		data, err := os.ReadFile(fsr.keyPath(name))
+		if err != nil {
+			return "", nil, fmt.Errorf("reading key '%s': %w", name, err)
+		}
+		if isSealed(data) {
+			return name, data, nil
+		}
+	}
+	return "", nil, nil
+}
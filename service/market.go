@@ -0,0 +1,237 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/builtin/v11/market"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+)
+
+var MarketCmd = &cli.Command{
+	Name:  "market",
+	Usage: "Manage storage market escrow with keys held by this tool",
+	Subcommands: []*cli.Command{
+		marketAddBalance,
+		marketWithdraw,
+	},
+}
+
+var marketAddBalance = &cli.Command{
+	Name:      "add-balance",
+	Usage:     "Add funds to the market escrow of a client or provider",
+	ArgsUsage: "<address> <amount (FIL)>",
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:  "from",
+			Usage: "account to send the funds from, default: the address itself, or the owner of a miner",
+		},
+		&cli.BoolFlag{
+			Name:  "yes",
+			Usage: "do not ask for confirmation",
+		},
+	}, submitFlags...),
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 2 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+
+		addr, err := address.NewFromString(cctx.Args().Get(0))
+		if err != nil {
+			return xerrors.Errorf("parsing address: %w", err)
+		}
+		f, err := types.ParseFIL(cctx.Args().Get(1))
+		if err != nil {
+			return xerrors.Errorf("parsing amount: %w", err)
+		}
+		amount := abi.TokenAmount(f)
+		if amount.Sign() <= 0 {
+			return xerrors.Errorf("amount must be positive")
+		}
+
+		srv, err := NewLotusService(cctx)
+		if err != nil {
+			return err
+		}
+		defer srv.Close() //nolint:errcheck
+
+		ctx := lcli.ReqContext(cctx)
+		w := cctx.App.Writer
+
+		sender, err := srv.marketSender(ctx, cctx, addr)
+		if err != nil {
+			return err
+		}
+
+		before, err := srv.api.StateMarketBalance(ctx, addr, types.EmptyTSK)
+		if err != nil {
+			return xerrors.Errorf("getting market balance: %w", err)
+		}
+
+		proto, err := srv.ActorMessage(ctx, cctx, sender, builtin.StorageMarketActorAddr, builtin.MethodsMarket.AddBalance, amount, &addr)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "Adding %s to the market escrow of %s from %s\n", types.FIL(amount), addr, proto.Message.From)
+		printMarketBalance(w, before, api.MarketBalance{
+			Escrow: big.Add(before.Escrow, amount),
+			Locked: before.Locked,
+		})
+		if !confirmSubmit(cctx, "Add the funds? [yes/No]: ") {
+			return ErrAbortedByUser
+		}
+
+		lookup, err := SubmitMessage(ctx, cctx, srv, proto)
+		if err != nil || lookup == nil {
+			return err
+		}
+
+		return srv.printMarketBalanceAt(ctx, w, addr, lookup.TipSet)
+	},
+}
+
+var marketWithdraw = &cli.Command{
+	Name:      "withdraw",
+	Usage:     "Withdraw available funds from the market escrow of a client or provider",
+	ArgsUsage: "<address> [amount (FIL), default: all available]",
+	Description: `Client funds are withdrawn to the client address. Provider funds can be
+   withdrawn by the owner or worker and are always sent to the owner.`,
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:  "from",
+			Usage: "account to send the withdrawal from, default: the address itself, or the owner of a miner",
+		},
+		&cli.BoolFlag{
+			Name:  "yes",
+			Usage: "do not ask for confirmation",
+		},
+	}, submitFlags...),
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() < 1 || cctx.NArg() > 2 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+
+		addr, err := address.NewFromString(cctx.Args().First())
+		if err != nil {
+			return xerrors.Errorf("parsing address: %w", err)
+		}
+
+		srv, err := NewLotusService(cctx)
+		if err != nil {
+			return err
+		}
+		defer srv.Close() //nolint:errcheck
+
+		ctx := lcli.ReqContext(cctx)
+		w := cctx.App.Writer
+
+		sender, err := srv.marketSender(ctx, cctx, addr)
+		if err != nil {
+			return err
+		}
+
+		before, err := srv.api.StateMarketBalance(ctx, addr, types.EmptyTSK)
+		if err != nil {
+			return xerrors.Errorf("getting market balance: %w", err)
+		}
+		available := big.Sub(before.Escrow, before.Locked)
+
+		amount := available
+		if cctx.NArg() == 2 {
+			f, err := types.ParseFIL(cctx.Args().Get(1))
+			if err != nil {
+				return xerrors.Errorf("parsing amount: %w", err)
+			}
+			amount = abi.TokenAmount(f)
+
+			if amount.GreaterThan(available) {
+				return xerrors.Errorf("can't withdraw more funds than available; requested: %s; available: %s",
+					types.FIL(amount), types.FIL(available))
+			}
+		}
+		if amount.Sign() <= 0 {
+			return xerrors.Errorf("%s has no available market balance to withdraw", addr)
+		}
+
+		proto, err := srv.ActorMessage(ctx, cctx, sender, builtin.StorageMarketActorAddr, builtin.MethodsMarket.WithdrawBalance, big.Zero(), &market.WithdrawBalanceParams{
+			ProviderOrClientAddress: addr,
+			Amount:                  amount,
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "Withdrawing %s of %s available from the market escrow of %s\n", types.FIL(amount), types.FIL(available), addr)
+		printMarketBalance(w, before, api.MarketBalance{
+			Escrow: big.Sub(before.Escrow, amount),
+			Locked: before.Locked,
+		})
+		if !confirmSubmit(cctx, "Withdraw the funds? [yes/No]: ") {
+			return ErrAbortedByUser
+		}
+
+		lookup, err := SubmitMessage(ctx, cctx, srv, proto)
+		if err != nil || lookup == nil {
+			return err
+		}
+
+		var withdrawn abi.TokenAmount
+		if err := withdrawn.UnmarshalCBOR(bytes.NewReader(lookup.Receipt.Return)); err == nil {
+			fmt.Fprintf(w, "Withdrawn: %s\n", types.FIL(withdrawn))
+		}
+		return srv.printMarketBalanceAt(ctx, w, addr, lookup.TipSet)
+	},
+}
+
+// marketSender returns the address managing the escrow of addr: --from if
+// set, the owner for a miner, otherwise addr itself
+func (s *LotusService) marketSender(ctx context.Context, cctx *cli.Context, addr address.Address) (address.Address, error) {
+	if f := cctx.String("from"); f != "" {
+		from, err := address.NewFromString(f)
+		if err != nil {
+			return address.Undef, xerrors.Errorf("parsing from address: %w", err)
+		}
+		return from, nil
+	}
+
+	id, err := s.api.StateLookupID(ctx, addr, types.EmptyTSK)
+	if err != nil {
+		// not on chain yet, only a client can get funds added this way
+		return addr, nil
+	}
+	if mi, err := s.api.StateMinerInfo(ctx, id, types.EmptyTSK); err == nil {
+		return mi.Owner, nil
+	}
+	return addr, nil
+}
+
+func printMarketBalance(w io.Writer, before, after api.MarketBalance) {
+	fmt.Fprintf(w, "             %-24s %s\n", "Before", "After")
+	fmt.Fprintf(w, "  Escrow:    %-24s %s\n", types.FIL(before.Escrow), types.FIL(after.Escrow))
+	fmt.Fprintf(w, "  Locked:    %-24s %s\n", types.FIL(before.Locked), types.FIL(after.Locked))
+	fmt.Fprintf(w, "  Available: %-24s %s\n", types.FIL(big.Sub(before.Escrow, before.Locked)), types.FIL(big.Sub(after.Escrow, after.Locked)))
+}
+
+func (s *LotusService) printMarketBalanceAt(ctx context.Context, w io.Writer, addr address.Address, tsk types.TipSetKey) error {
+	mbal, err := s.api.StateMarketBalance(ctx, addr, tsk)
+	if err != nil {
+		return xerrors.Errorf("getting market balance: %w", err)
+	}
+	fmt.Fprintf(w, "Market balance of %s:\n", addr)
+	fmt.Fprintf(w, "  Escrow:    %s\n", types.FIL(mbal.Escrow))
+	fmt.Fprintf(w, "  Locked:    %s\n", types.FIL(mbal.Locked))
+	fmt.Fprintf(w, "  Available: %s\n", types.FIL(big.Sub(mbal.Escrow, mbal.Locked)))
+	return nil
+}
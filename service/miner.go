@@ -0,0 +1,249 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/builtin/v11/miner"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+)
+
+var MinerCmd = &cli.Command{
+	Name:  "miner",
+	Usage: "Manage storage provider actors with keys held by this tool",
+	Subcommands: []*cli.Command{
+		minerWithdraw,
+		minerSetOwner,
+		minerControl,
+		minerProposeChangeWorker,
+		minerConfirmChangeWorker,
+		minerBeneficiary,
+	},
+}
+
+// submitFlags are the flags of commands sending their message with
+// SubmitMessage
+var submitFlags = append([]cli.Flag{
+	&cli.StringFlag{
+		Name:  "unsigned-out",
+		Usage: "fill nonce and gas, then write the unsigned message to this file instead of sending it",
+	},
+	&cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "simulate the message against the current head without signing or sending it",
+	},
+}, waitFlags...)
+
+var minerWithdraw = &cli.Command{
+	Name:      "withdraw",
+	Usage:     "Withdraw available balance from a miner actor",
+	ArgsUsage: "<miner address> [amount (FIL), default: all available]",
+	Flags: append([]cli.Flag{
+		&cli.BoolFlag{
+			Name:  "beneficiary",
+			Usage: "send the withdrawal from the beneficiary instead of the owner address",
+		},
+	}, submitFlags...),
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() < 1 || cctx.NArg() > 2 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+
+		maddr, err := address.NewFromString(cctx.Args().First())
+		if err != nil {
+			return xerrors.Errorf("parsing miner address: %w", err)
+		}
+
+		srv, err := NewLotusService(cctx)
+		if err != nil {
+			return err
+		}
+		defer srv.Close() //nolint:errcheck
+
+		ctx := lcli.ReqContext(cctx)
+		w := cctx.App.Writer
+
+		mi, err := srv.api.StateMinerInfo(ctx, maddr, types.EmptyTSK)
+		if err != nil {
+			return xerrors.Errorf("getting miner info: %w", err)
+		}
+
+		sender := mi.Owner
+		if cctx.Bool("beneficiary") {
+			sender = mi.Beneficiary
+		}
+
+		available, err := srv.api.StateMinerAvailableBalance(ctx, maddr, types.EmptyTSK)
+		if err != nil {
+			return xerrors.Errorf("getting miner available balance: %w", err)
+		}
+
+		amount := available
+		if cctx.NArg() == 2 {
+			f, err := types.ParseFIL(cctx.Args().Get(1))
+			if err != nil {
+				return xerrors.Errorf("parsing amount: %w", err)
+			}
+			amount = abi.TokenAmount(f)
+
+			if amount.GreaterThan(available) {
+				return xerrors.Errorf("can't withdraw more funds than available; requested: %s; available: %s",
+					types.FIL(amount), types.FIL(available))
+			}
+		}
+		if amount.IsZero() {
+			return xerrors.Errorf("miner %s has no available balance to withdraw", maddr)
+		}
+
+		proto, err := srv.MinerMessage(ctx, cctx, sender, maddr, builtin.MethodsMiner.WithdrawBalance, &miner.WithdrawBalanceParams{
+			AmountRequested: amount,
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "Withdrawing %s of %s available from %s\n", types.FIL(amount), types.FIL(available), maddr)
+
+		lookup, err := SubmitMessage(ctx, cctx, srv, proto)
+		if err != nil || lookup == nil {
+			return err
+		}
+
+		var withdrawn abi.TokenAmount
+		if err := withdrawn.UnmarshalCBOR(bytes.NewReader(lookup.Receipt.Return)); err != nil {
+			// actors before v9 don't return the amount
+			return nil
+		}
+		fmt.Fprintf(w, "Withdrawn:   %s\n", types.FIL(withdrawn))
+		return nil
+	},
+}
+
+// SubmitMessage handles a prototype according to submitFlags: it is either
+// written to --unsigned-out, simulated with --dry-run, or signed and pushed.
+// The lookup is returned if the message was waited for.
+func SubmitMessage(ctx context.Context, cctx *cli.Context, srv *LotusService, proto *api.MessagePrototype) (*api.MsgLookup, error) {
+	if out := cctx.String("unsigned-out"); out != "" {
+		msg, err := srv.FillMessage(ctx, proto)
+		if err != nil {
+			return nil, err
+		}
+		if err := WriteMessageFile(out, MessageFileUnsigned, msg, nil); err != nil {
+			return nil, err
+		}
+		if out != "-" {
+			fmt.Fprintf(cctx.App.Writer, "unsigned message written to %s, sign it with 'sign-message' and send it with 'push'\n", out)
+		}
+		return nil, nil
+	}
+
+	if cctx.Bool("dry-run") {
+		return nil, DryRun(ctx, cctx.App.Writer, srv, proto)
+	}
+
+	sm, err := InteractiveSend(ctx, cctx, srv, proto)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(cctx.App.Writer, "Message CID: %s\n", sm.Cid())
+
+	if !cctx.Bool("wait") {
+		return nil, nil
+	}
+	return WaitMessage(ctx, cctx, srv, sm.Cid())
+}
+
+// confirmSubmit asks before sending a message, unless --yes is set or the
+// message is only exported or simulated
+func confirmSubmit(cctx *cli.Context, prompt string) bool {
+	if cctx.Bool("yes") || cctx.String("unsigned-out") != "" || cctx.Bool("dry-run") {
+		return true
+	}
+	return askUser(cctx.App.Writer, prompt, false)
+}
+
+// MinerMessage builds a message invoking method on the miner maddr, sent by
+// sender, see SenderKey
+func (s *LotusService) MinerMessage(ctx context.Context, cctx *cli.Context, sender, maddr address.Address, method abi.MethodNum, params interface{}) (*api.MessagePrototype, error) {
+	return s.ActorMessage(ctx, cctx, sender, maddr, method, big.Zero(), params)
+}
+
+// ActorMessage builds a message invoking method on the actor to, transferring
+// value. Params are encoded with the types of the network's actors version.
+func (s *LotusService) ActorMessage(ctx context.Context, cctx *cli.Context, sender, to address.Address, method abi.MethodNum, value abi.TokenAmount, params interface{}) (*api.MessagePrototype, error) {
+	from, err := s.SenderKey(ctx, cctx, sender)
+	if err != nil {
+		return nil, err
+	}
+
+	enc, err := s.EncodeParams(ctx, to, method, params)
+	if err != nil {
+		return nil, xerrors.Errorf("encoding params: %w", err)
+	}
+
+	proto, err := s.MessageForSend(ctx, lcli.SendParams{
+		From:   from,
+		To:     to,
+		Val:    value,
+		Method: method,
+		Params: enc,
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("creating message prototype: %w", err)
+	}
+	return proto, nil
+}
+
+// SenderKey returns the key address sending as addr. The key has to be in the
+// local wallet unless the message is only exported with --unsigned-out or
+// simulated with --dry-run.
+func (s *LotusService) SenderKey(ctx context.Context, cctx *cli.Context, addr address.Address) (address.Address, error) {
+	from, err := s.LocalSigner(ctx, addr)
+	if err == nil {
+		return from, nil
+	}
+	if cctx.String("unsigned-out") == "" && !cctx.Bool("dry-run") {
+		return address.Undef, xerrors.Errorf("%w; export the message with --unsigned-out, sign it where the key is with 'sign-message' and broadcast it with 'push'", err)
+	}
+	return s.keyAddress(ctx, addr)
+}
+
+// LocalSigner resolves addr to its key address and checks the local wallet
+// holds the key
+func (s *LotusService) LocalSigner(ctx context.Context, addr address.Address) (address.Address, error) {
+	key, err := s.keyAddress(ctx, addr)
+	if err != nil {
+		return address.Undef, err
+	}
+
+	has, err := s.wallet.WalletHas(ctx, key)
+	if err != nil {
+		return address.Undef, err
+	}
+	if !has {
+		return address.Undef, xerrors.Errorf("key of %s (%s) is not in the local wallet", addr, key)
+	}
+	return key, nil
+}
+
+func (s *LotusService) keyAddress(ctx context.Context, addr address.Address) (address.Address, error) {
+	if addr.Protocol() != address.ID {
+		return addr, nil
+	}
+
+	key, err := s.api.StateAccountKey(ctx, addr, types.EmptyTSK)
+	if err != nil {
+		return address.Undef, xerrors.Errorf("resolving key address of %s: %w", addr, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,296 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/builtin/v11/miner"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+)
+
+var minerBeneficiary = &cli.Command{
+	Name:  "beneficiary",
+	Usage: "Manage the beneficiary of a miner (FIP-0029)",
+	Description: `Changing the beneficiary is proposed by the owner and has to be approved by
+   the nominee and, unless it is the owner, by the current beneficiary. All of
+   them send ChangeBeneficiary with the same params.`,
+	Subcommands: []*cli.Command{
+		minerBeneficiaryShow,
+		minerBeneficiaryPropose,
+		minerBeneficiaryApprove,
+	},
+}
+
+var minerBeneficiaryShow = &cli.Command{
+	Name:      "show",
+	Usage:     "Show the beneficiary term and the pending change of a miner",
+	ArgsUsage: "<miner address>",
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+
+		maddr, err := address.NewFromString(cctx.Args().First())
+		if err != nil {
+			return xerrors.Errorf("parsing miner address: %w", err)
+		}
+
+		srv, err := NewLotusService(cctx)
+		if err != nil {
+			return err
+		}
+		defer srv.Close() //nolint:errcheck
+
+		ctx := lcli.ReqContext(cctx)
+
+		mi, err := srv.api.StateMinerInfo(ctx, maddr, types.EmptyTSK)
+		if err != nil {
+			return xerrors.Errorf("getting miner info: %w", err)
+		}
+		head, err := srv.api.ChainHead(ctx)
+		if err != nil {
+			return err
+		}
+
+		printBeneficiary(cctx.App.Writer, mi, head.Height())
+		return nil
+	},
+}
+
+func printBeneficiary(w io.Writer, mi api.MinerInfo, height abi.ChainEpoch) {
+	fmt.Fprintf(w, "Owner:        %s\n", mi.Owner)
+	fmt.Fprintf(w, "Beneficiary:  %s\n", mi.Beneficiary)
+	if t := mi.BeneficiaryTerm; t != nil && mi.Beneficiary != mi.Owner {
+		expired := ""
+		if t.Expiration <= height {
+			expired = " (expired)"
+		}
+		fmt.Fprintf(w, "Quota:        %s\n", types.FIL(t.Quota))
+		fmt.Fprintf(w, "Used Quota:   %s\n", types.FIL(t.UsedQuota))
+		fmt.Fprintf(w, "Remaining:    %s\n", types.FIL(big.Max(big.Sub(t.Quota, t.UsedQuota), big.Zero())))
+		fmt.Fprintf(w, "Expiration:   %d%s\n", t.Expiration, expired)
+	}
+
+	p := mi.PendingBeneficiaryTerm
+	if p == nil {
+		fmt.Fprintln(w, "Pending:      none")
+		return
+	}
+	fmt.Fprintln(w, "Pending Change:")
+	fmt.Fprintf(w, "  Nominee:                 %s\n", p.NewBeneficiary)
+	fmt.Fprintf(w, "  Quota:                   %s\n", types.FIL(p.NewQuota))
+	fmt.Fprintf(w, "  Expiration:              %d\n", p.NewExpiration)
+	fmt.Fprintf(w, "  Approved by nominee:     %t\n", p.ApprovedByNominee)
+	fmt.Fprintf(w, "  Approved by beneficiary: %t\n", p.ApprovedByBeneficiary)
+}
+
+var minerBeneficiaryPropose = &cli.Command{
+	Name:      "propose",
+	Usage:     "Propose a new beneficiary, sent by the owner",
+	ArgsUsage: "<miner address> <beneficiary address> <quota (FIL)> <expiration epoch>",
+	Flags: append([]cli.Flag{
+		&cli.BoolFlag{
+			Name:  "yes",
+			Usage: "do not ask for confirmation",
+		},
+	}, submitFlags...),
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 4 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+
+		maddr, err := address.NewFromString(cctx.Args().Get(0))
+		if err != nil {
+			return xerrors.Errorf("parsing miner address: %w", err)
+		}
+		nominee, err := address.NewFromString(cctx.Args().Get(1))
+		if err != nil {
+			return xerrors.Errorf("parsing beneficiary address: %w", err)
+		}
+		quota, err := types.ParseFIL(cctx.Args().Get(2))
+		if err != nil {
+			return xerrors.Errorf("parsing quota: %w", err)
+		}
+		expiration, err := strconv.ParseInt(cctx.Args().Get(3), 10, 64)
+		if err != nil {
+			return xerrors.Errorf("parsing expiration: %w", err)
+		}
+
+		srv, err := NewLotusService(cctx)
+		if err != nil {
+			return err
+		}
+		defer srv.Close() //nolint:errcheck
+
+		ctx := lcli.ReqContext(cctx)
+		w := cctx.App.Writer
+
+		mi, err := srv.api.StateMinerInfo(ctx, maddr, types.EmptyTSK)
+		if err != nil {
+			return xerrors.Errorf("getting miner info: %w", err)
+		}
+
+		nomineeID, err := srv.api.StateLookupID(ctx, nominee, types.EmptyTSK)
+		if err != nil {
+			return xerrors.Errorf("looking up %s, it must exist on chain: %w", nominee, err)
+		}
+
+		params := &miner.ChangeBeneficiaryParams{
+			NewBeneficiary: nomineeID,
+			NewQuota:       abi.TokenAmount(quota),
+			NewExpiration:  abi.ChainEpoch(expiration),
+		}
+
+		fmt.Fprintf(w, "Proposing beneficiary %s (%s) for %s\n", nominee, nomineeID, maddr)
+		fmt.Fprintf(w, "  Quota:      %s\n", types.FIL(params.NewQuota))
+		fmt.Fprintf(w, "  Expiration: %d\n", params.NewExpiration)
+		if mi.PendingBeneficiaryTerm != nil {
+			fmt.Fprintf(w, "Warning: the pending change to %s will be replaced\n", mi.PendingBeneficiaryTerm.NewBeneficiary)
+		}
+		if !confirmSubmit(cctx, "Propose the beneficiary change? [yes/No]: ") {
+			return ErrAbortedByUser
+		}
+
+		proto, err := srv.MinerMessage(ctx, cctx, mi.Owner, maddr, builtin.MethodsMiner.ChangeBeneficiary, params)
+		if err != nil {
+			return err
+		}
+
+		lookup, err := SubmitMessage(ctx, cctx, srv, proto)
+		if err != nil || lookup == nil {
+			return err
+		}
+
+		mi, err = srv.api.StateMinerInfo(ctx, maddr, lookup.TipSet)
+		if err != nil {
+			return xerrors.Errorf("getting miner info: %w", err)
+		}
+		printBeneficiary(w, mi, lookup.Height)
+		return nil
+	},
+}
+
+var minerBeneficiaryApprove = &cli.Command{
+	Name:      "approve",
+	Usage:     "Approve the pending beneficiary change with the nominee and current beneficiary keys",
+	ArgsUsage: "<miner address>",
+	Description: `Sends the approvals still missing for the pending change with every required
+   key in the local wallet. Use --as to approve for one party only, e.g. to
+   export its approval with --unsigned-out when the key lives elsewhere.`,
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:  "as",
+			Usage: "only approve as 'nominee' or 'beneficiary'",
+		},
+		&cli.BoolFlag{
+			Name:  "yes",
+			Usage: "do not ask for confirmation",
+		},
+	}, submitFlags...),
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+
+		maddr, err := address.NewFromString(cctx.Args().First())
+		if err != nil {
+			return xerrors.Errorf("parsing miner address: %w", err)
+		}
+
+		as := cctx.String("as")
+		if as != "" && as != "nominee" && as != "beneficiary" {
+			return xerrors.Errorf("--as must be 'nominee' or 'beneficiary', got %q", as)
+		}
+
+		srv, err := NewLotusService(cctx)
+		if err != nil {
+			return err
+		}
+		defer srv.Close() //nolint:errcheck
+
+		ctx := lcli.ReqContext(cctx)
+		w := cctx.App.Writer
+
+		mi, err := srv.api.StateMinerInfo(ctx, maddr, types.EmptyTSK)
+		if err != nil {
+			return xerrors.Errorf("getting miner info: %w", err)
+		}
+		p := mi.PendingBeneficiaryTerm
+		if p == nil {
+			return xerrors.Errorf("no beneficiary change pending for %s", maddr)
+		}
+
+		type approver struct {
+			role string
+			addr address.Address
+		}
+		var missing []approver
+		if !p.ApprovedByNominee {
+			missing = append(missing, approver{"nominee", p.NewBeneficiary})
+		}
+		// the owner as current beneficiary approves by proposing
+		if !p.ApprovedByBeneficiary && mi.Beneficiary != mi.Owner {
+			missing = append(missing, approver{"beneficiary", mi.Beneficiary})
+		}
+
+		var todo []approver
+		for _, a := range missing {
+			if as != "" && a.role != as {
+				continue
+			}
+			if as == "" {
+				if _, err := srv.LocalSigner(ctx, a.addr); err != nil {
+					fmt.Fprintf(w, "Skipping approval by the %s %s: %s\n", a.role, a.addr, err)
+					continue
+				}
+			}
+			todo = append(todo, a)
+		}
+		if len(todo) == 0 {
+			if len(missing) == 0 {
+				return xerrors.Errorf("the pending change has all approvals")
+			}
+			return xerrors.Errorf("no approval to send from this wallet, approve with --as on the machine holding the key or export it with --as and --unsigned-out")
+		}
+		if len(todo) > 1 && cctx.String("unsigned-out") != "" {
+			return xerrors.Errorf("both nominee and beneficiary have to approve, select one with --as to export its approval")
+		}
+
+		head, err := srv.api.ChainHead(ctx)
+		if err != nil {
+			return err
+		}
+		printBeneficiary(w, mi, head.Height())
+
+		params := &miner.ChangeBeneficiaryParams{
+			NewBeneficiary: p.NewBeneficiary,
+			NewQuota:       p.NewQuota,
+			NewExpiration:  p.NewExpiration,
+		}
+
+		for _, a := range todo {
+			if !confirmSubmit(cctx, fmt.Sprintf("Approve as %s %s? [yes/No]: ", a.role, a.addr)) {
+				return ErrAbortedByUser
+			}
+
+			proto, err := srv.MinerMessage(ctx, cctx, a.addr, maddr, builtin.MethodsMiner.ChangeBeneficiary, params)
+			if err != nil {
+				return err
+			}
+			if _, err := SubmitMessage(ctx, cctx, srv, proto); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
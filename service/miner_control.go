@@ -0,0 +1,359 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/builtin/v11/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/filecoin-project/lotus/lib/tablewriter"
+)
+
+var minerControl = &cli.Command{
+	Name:  "control",
+	Usage: "Manage the control addresses of a miner",
+	Subcommands: []*cli.Command{
+		minerControlList,
+		minerControlSet,
+	},
+}
+
+var minerControlList = &cli.Command{
+	Name:      "list",
+	Usage:     "List the owner, worker, control and beneficiary addresses of a miner",
+	ArgsUsage: "<miner address>",
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+
+		maddr, err := address.NewFromString(cctx.Args().First())
+		if err != nil {
+			return xerrors.Errorf("parsing miner address: %w", err)
+		}
+
+		srv, err := NewLotusService(cctx)
+		if err != nil {
+			return err
+		}
+		defer srv.Close() //nolint:errcheck
+
+		ctx := lcli.ReqContext(cctx)
+
+		mi, err := srv.api.StateMinerInfo(ctx, maddr, types.EmptyTSK)
+		if err != nil {
+			return xerrors.Errorf("getting miner info: %w", err)
+		}
+
+		tw := tablewriter.New(
+			tablewriter.Col("Role"),
+			tablewriter.Col("ID"),
+			tablewriter.Col("Address"),
+			tablewriter.Col("Balance"),
+			tablewriter.Col("Nonce"),
+			tablewriter.Col("Local"),
+			tablewriter.NewLineCol("Error"))
+
+		write := func(role string, id address.Address) {
+			row := map[string]interface{}{
+				"Role": role,
+				"ID":   id,
+			}
+
+			key, err := srv.keyAddress(ctx, id)
+			if err != nil {
+				// not an account, e.g. a multisig owner
+				key = id
+			}
+			row["Address"] = key
+
+			if has, err := srv.wallet.WalletHas(ctx, key); err == nil && has {
+				row["Local"] = "yes"
+			}
+
+			a, err := srv.api.StateGetActor(ctx, id, types.EmptyTSK)
+			if err != nil {
+				row["Error"] = err
+			} else {
+				row["Balance"] = types.FIL(a.Balance)
+				row["Nonce"] = a.Nonce
+			}
+			tw.Write(row)
+		}
+
+		write("owner", mi.Owner)
+		write("worker", mi.Worker)
+		for i, ca := range mi.ControlAddresses {
+			write(fmt.Sprintf("control-%d", i), ca)
+		}
+		if mi.Beneficiary != address.Undef {
+			write("beneficiary", mi.Beneficiary)
+		}
+		if mi.NewWorker != address.Undef {
+			write(fmt.Sprintf("new worker (from epoch %d)", mi.WorkerChangeEpoch), mi.NewWorker)
+		}
+
+		return tw.Flush(cctx.App.Writer)
+	},
+}
+
+var minerControlSet = &cli.Command{
+	Name:      "set",
+	Usage:     "Replace the control addresses of a miner",
+	ArgsUsage: "<miner address> [...control address]",
+	Description: `Sets the complete list of control addresses, addresses not given are removed.
+   The message is sent by the owner, the worker address is left unchanged.`,
+	Flags: append([]cli.Flag{
+		&cli.BoolFlag{
+			Name:  "yes",
+			Usage: "do not ask for confirmation",
+		},
+	}, submitFlags...),
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() < 1 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+
+		maddr, err := address.NewFromString(cctx.Args().First())
+		if err != nil {
+			return xerrors.Errorf("parsing miner address: %w", err)
+		}
+
+		srv, err := NewLotusService(cctx)
+		if err != nil {
+			return err
+		}
+		defer srv.Close() //nolint:errcheck
+
+		ctx := lcli.ReqContext(cctx)
+		w := cctx.App.Writer
+
+		mi, err := srv.api.StateMinerInfo(ctx, maddr, types.EmptyTSK)
+		if err != nil {
+			return xerrors.Errorf("getting miner info: %w", err)
+		}
+
+		var controls []address.Address
+		for _, s := range cctx.Args().Slice()[1:] {
+			id, err := srv.accountID(ctx, s)
+			if err != nil {
+				return err
+			}
+			controls = append(controls, id)
+		}
+
+		fmt.Fprintf(w, "Control addresses of %s:\n", maddr)
+		fmt.Fprintf(w, "  current: %s\n", addrList(mi.ControlAddresses))
+		fmt.Fprintf(w, "  new:     %s\n", addrList(controls))
+		if !confirmSubmit(cctx, "Change the control addresses? [yes/No]: ") {
+			return ErrAbortedByUser
+		}
+
+		proto, err := srv.MinerMessage(ctx, cctx, mi.Owner, maddr, builtin.MethodsMiner.ChangeWorkerAddress, &miner.ChangeWorkerAddressParams{
+			NewWorker:       mi.Worker,
+			NewControlAddrs: controls,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = SubmitMessage(ctx, cctx, srv, proto)
+		return err
+	},
+}
+
+var minerProposeChangeWorker = &cli.Command{
+	Name:      "propose-change-worker",
+	Usage:     "Propose a new worker address, it can be confirmed after the change delay",
+	ArgsUsage: "<miner address> <new worker address>",
+	Flags: append([]cli.Flag{
+		&cli.BoolFlag{
+			Name:  "yes",
+			Usage: "do not ask for confirmation",
+		},
+	}, submitFlags...),
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 2 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+
+		maddr, err := address.NewFromString(cctx.Args().Get(0))
+		if err != nil {
+			return xerrors.Errorf("parsing miner address: %w", err)
+		}
+
+		srv, err := NewLotusService(cctx)
+		if err != nil {
+			return err
+		}
+		defer srv.Close() //nolint:errcheck
+
+		ctx := lcli.ReqContext(cctx)
+		w := cctx.App.Writer
+
+		mi, err := srv.api.StateMinerInfo(ctx, maddr, types.EmptyTSK)
+		if err != nil {
+			return xerrors.Errorf("getting miner info: %w", err)
+		}
+
+		newWorker, err := srv.accountID(ctx, cctx.Args().Get(1))
+		if err != nil {
+			return err
+		}
+
+		if mi.NewWorker == newWorker {
+			return xerrors.Errorf("worker change to %s already proposed, confirm it with 'confirm-change-worker' from epoch %d", newWorker, mi.WorkerChangeEpoch)
+		}
+		// the miner actor keeps a pending worker change until it's confirmed,
+		// proposing another one would only rewrite the control addresses
+		if mi.NewWorker != address.Undef {
+			return xerrors.Errorf("worker change to %s is pending, confirm it with 'confirm-change-worker' from epoch %d before proposing another one", mi.NewWorker, mi.WorkerChangeEpoch)
+		}
+		if mi.Worker == newWorker {
+			return xerrors.Errorf("%s already is the worker of %s", newWorker, maddr)
+		}
+
+		fmt.Fprintf(w, "Proposing %s as the new worker of %s, current worker: %s\n", newWorker, maddr, mi.Worker)
+		if !confirmSubmit(cctx, "Propose the worker change? [yes/No]: ") {
+			return ErrAbortedByUser
+		}
+
+		proto, err := srv.MinerMessage(ctx, cctx, mi.Owner, maddr, builtin.MethodsMiner.ChangeWorkerAddress, &miner.ChangeWorkerAddressParams{
+			NewWorker:       newWorker,
+			NewControlAddrs: mi.ControlAddresses,
+		})
+		if err != nil {
+			return err
+		}
+
+		lookup, err := SubmitMessage(ctx, cctx, srv, proto)
+		if err != nil || lookup == nil {
+			return err
+		}
+
+		mi, err = srv.api.StateMinerInfo(ctx, maddr, lookup.TipSet)
+		if err != nil {
+			return xerrors.Errorf("getting miner info: %w", err)
+		}
+		if mi.NewWorker != newWorker {
+			return xerrors.Errorf("message executed but the pending worker of %s is %s, not %s", maddr, mi.NewWorker, newWorker)
+		}
+		fmt.Fprintf(w, "Worker change to %s can be confirmed with 'confirm-change-worker' from epoch %d\n", newWorker, mi.WorkerChangeEpoch)
+		return nil
+	},
+}
+
+var minerConfirmChangeWorker = &cli.Command{
+	Name:      "confirm-change-worker",
+	Usage:     "Confirm a proposed worker address change",
+	ArgsUsage: "<miner address> <new worker address>",
+	Flags: append([]cli.Flag{
+		&cli.BoolFlag{
+			Name:  "yes",
+			Usage: "do not ask for confirmation",
+		},
+	}, submitFlags...),
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 2 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+
+		maddr, err := address.NewFromString(cctx.Args().Get(0))
+		if err != nil {
+			return xerrors.Errorf("parsing miner address: %w", err)
+		}
+
+		srv, err := NewLotusService(cctx)
+		if err != nil {
+			return err
+		}
+		defer srv.Close() //nolint:errcheck
+
+		ctx := lcli.ReqContext(cctx)
+		w := cctx.App.Writer
+
+		mi, err := srv.api.StateMinerInfo(ctx, maddr, types.EmptyTSK)
+		if err != nil {
+			return xerrors.Errorf("getting miner info: %w", err)
+		}
+
+		newWorker, err := srv.accountID(ctx, cctx.Args().Get(1))
+		if err != nil {
+			return err
+		}
+
+		if mi.NewWorker == address.Undef {
+			return xerrors.Errorf("no worker change proposed for %s", maddr)
+		}
+		if mi.NewWorker != newWorker {
+			return xerrors.Errorf("the proposed worker is %s, not %s", mi.NewWorker, newWorker)
+		}
+
+		head, err := srv.api.ChainHead(ctx)
+		if err != nil {
+			return err
+		}
+		if head.Height() < mi.WorkerChangeEpoch {
+			return xerrors.Errorf("worker change can be confirmed from epoch %d, current epoch is %d", mi.WorkerChangeEpoch, head.Height())
+		}
+
+		fmt.Fprintf(w, "Confirming %s as the new worker of %s, current worker: %s\n", newWorker, maddr, mi.Worker)
+		if !confirmSubmit(cctx, "Confirm the worker change? [yes/No]: ") {
+			return ErrAbortedByUser
+		}
+
+		proto, err := srv.MinerMessage(ctx, cctx, mi.Owner, maddr, builtin.MethodsMiner.ConfirmChangeWorkerAddress, nil)
+		if err != nil {
+			return err
+		}
+
+		lookup, err := SubmitMessage(ctx, cctx, srv, proto)
+		if err != nil || lookup == nil {
+			return err
+		}
+
+		mi, err = srv.api.StateMinerInfo(ctx, maddr, lookup.TipSet)
+		if err != nil {
+			return xerrors.Errorf("getting miner info: %w", err)
+		}
+		fmt.Fprintf(w, "Worker of %s is now %s\n", maddr, mi.Worker)
+		return nil
+	},
+}
+
+// accountID resolves s to the ID address of an account actor, miner worker
+// and control addresses have to be accounts
+func (s *LotusService) accountID(ctx context.Context, str string) (address.Address, error) {
+	addr, err := address.NewFromString(str)
+	if err != nil {
+		return address.Undef, xerrors.Errorf("parsing address %q: %w", str, err)
+	}
+
+	id, err := s.api.StateLookupID(ctx, addr, types.EmptyTSK)
+	if err != nil {
+		return address.Undef, xerrors.Errorf("looking up %s, it must exist on chain: %w", addr, err)
+	}
+
+	if _, err := s.api.StateAccountKey(ctx, id, types.EmptyTSK); err != nil {
+		return address.Undef, xerrors.Errorf("%s is not an account actor: %w", addr, err)
+	}
+	return id, nil
+}
+
+func addrList(addrs []address.Address) string {
+	if len(addrs) == 0 {
+		return "none"
+	}
+	s := make([]string, len(addrs))
+	for i, a := range addrs {
+		s[i] = a.String()
+	}
+	return strings.Join(s, ", ")
+}
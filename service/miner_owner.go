@@ -0,0 +1,189 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/builtin/v11/miner"
+	miner8 "github.com/filecoin-project/go-state-types/builtin/v8/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+)
+
+var minerSetOwner = &cli.Command{
+	Name:      "set-owner",
+	Usage:     "Change the owner of a miner, proposing and confirming the change",
+	ArgsUsage: "<miner address> <new owner address>",
+	Description: `Changing the owner takes two ChangeOwnerAddress messages: the current owner
+   proposes the new owner, then the new owner confirms. The pending step is read
+   from the miner state, run the command again to continue with the next step.
+
+   When both keys are in the local wallet both steps are sent in one run. When
+   the key for a step lives on another machine, export the message with
+   --unsigned-out, sign it there with 'sign-message' and broadcast it with 'push'.`,
+	Flags: append([]cli.Flag{
+		&cli.BoolFlag{
+			Name:  "yes",
+			Usage: "do not ask for confirmation",
+		},
+	}, submitFlags...),
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 2 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+
+		maddr, err := address.NewFromString(cctx.Args().Get(0))
+		if err != nil {
+			return xerrors.Errorf("parsing miner address: %w", err)
+		}
+		newOwner, err := address.NewFromString(cctx.Args().Get(1))
+		if err != nil {
+			return xerrors.Errorf("parsing new owner address: %w", err)
+		}
+
+		srv, err := NewLotusService(cctx)
+		if err != nil {
+			return err
+		}
+		defer srv.Close() //nolint:errcheck
+
+		ctx := lcli.ReqContext(cctx)
+		w := cctx.App.Writer
+
+		mi, err := srv.api.StateMinerInfo(ctx, maddr, types.EmptyTSK)
+		if err != nil {
+			return xerrors.Errorf("getting miner info: %w", err)
+		}
+		pending, err := srv.pendingOwner(ctx, maddr)
+		if err != nil {
+			return err
+		}
+
+		newID, err := srv.api.StateLookupID(ctx, newOwner, types.EmptyTSK)
+		if err != nil {
+			return xerrors.Errorf("looking up new owner %s, it must exist on chain: %w", newOwner, err)
+		}
+
+		if mi.Owner == newID {
+			fmt.Fprintf(w, "%s already is the owner of %s\n", newOwner, maddr)
+			return nil
+		}
+
+		exportOnly := cctx.String("unsigned-out") != "" || cctx.Bool("dry-run")
+
+		if pending == nil || *pending != newID {
+			if pending != nil {
+				fmt.Fprintf(w, "Warning: the pending proposal for %s will be replaced\n", *pending)
+			}
+			fmt.Fprintf(w, "Step 1/2: current owner %s proposes %s (%s) as the new owner of %s\n", mi.Owner, newOwner, newID, maddr)
+			if !confirmSubmit(cctx, "Send the proposal? [yes/No]: ") {
+				return ErrAbortedByUser
+			}
+
+			proto, err := srv.MinerMessage(ctx, cctx, mi.Owner, maddr, builtin.MethodsMiner.ChangeOwnerAddress, newID)
+			if err != nil {
+				return err
+			}
+
+			if exportOnly {
+				if _, err := SubmitMessage(ctx, cctx, srv, proto); err != nil {
+					return err
+				}
+				fmt.Fprintf(w, "Once the proposal is executed, run 'miner set-owner %s %s' again for step 2\n", maddr, newOwner)
+				return nil
+			}
+
+			sm, err := InteractiveSend(ctx, cctx, srv, proto)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "Proposal CID: %s\n", sm.Cid())
+
+			if _, err := srv.LocalSigner(ctx, newID); err != nil {
+				fmt.Fprintf(w, "Step 2/2 has to be signed by the new owner, its key is not in the local wallet.\n")
+				fmt.Fprintf(w, "Once %s is executed, run 'miner set-owner %s %s' on the machine holding the key,\n", sm.Cid(), maddr, newOwner)
+				fmt.Fprintf(w, "or export the confirmation from here with --unsigned-out.\n")
+				if cctx.Bool("wait") {
+					_, err := WaitMessage(ctx, cctx, srv, sm.Cid())
+					return err
+				}
+				return nil
+			}
+
+			// the confirmation is only accepted once the proposal is on chain,
+			// the error is returned as is to keep its exit code
+			if _, err := WaitMessage(ctx, cctx, srv, sm.Cid()); err != nil {
+				return err
+			}
+		}
+
+		fmt.Fprintf(w, "Step 2/2: new owner %s confirms ownership of %s\n", newOwner, maddr)
+		if !confirmSubmit(cctx, "Send the confirmation? [yes/No]: ") {
+			return ErrAbortedByUser
+		}
+
+		proto, err := srv.MinerMessage(ctx, cctx, newID, maddr, builtin.MethodsMiner.ChangeOwnerAddress, newID)
+		if err != nil {
+			return err
+		}
+
+		lookup, err := SubmitMessage(ctx, cctx, srv, proto)
+		if err != nil || lookup == nil {
+			return err
+		}
+
+		mi, err = srv.api.StateMinerInfo(ctx, maddr, types.EmptyTSK)
+		if err != nil {
+			return xerrors.Errorf("getting miner info: %w", err)
+		}
+		fmt.Fprintf(w, "Owner of %s is now %s\n", maddr, mi.Owner)
+		return nil
+	},
+}
+
+// pendingOwner reads the proposed new owner from the miner state, the miner
+// info returned by the api doesn't include it
+func (s *LotusService) pendingOwner(ctx context.Context, maddr address.Address) (*address.Address, error) {
+	act, err := s.api.StateGetActor(ctx, maddr, types.EmptyTSK)
+	if err != nil {
+		return nil, xerrors.Errorf("getting miner actor: %w", err)
+	}
+
+	head, err := s.api.ChainReadObj(ctx, act.Head)
+	if err != nil {
+		return nil, xerrors.Errorf("reading miner state: %w", err)
+	}
+
+	// every miner state version starts with the info cid
+	cr := cbg.NewCborReader(bytes.NewReader(head))
+	if maj, _, err := cr.ReadHeader(); err != nil || maj != cbg.MajArray {
+		return nil, xerrors.Errorf("miner state is not a cbor array: %v", err)
+	}
+	infoCid, err := cbg.ReadCid(cr)
+	if err != nil {
+		return nil, xerrors.Errorf("reading miner info cid: %w", err)
+	}
+
+	raw, err := s.api.ChainReadObj(ctx, infoCid)
+	if err != nil {
+		return nil, xerrors.Errorf("reading miner info: %w", err)
+	}
+
+	// the beneficiary fields were added in v9, the preceding fields are the same
+	var info miner.MinerInfo
+	if err := info.UnmarshalCBOR(bytes.NewReader(raw)); err == nil {
+		return info.PendingOwnerAddress, nil
+	}
+	var info8 miner8.MinerInfo
+	if err := info8.UnmarshalCBOR(bytes.NewReader(raw)); err != nil {
+		return nil, xerrors.Errorf("decoding miner info: %w", err)
+	}
+	return info8.PendingOwnerAddress, nil
+}
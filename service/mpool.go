@@ -0,0 +1,271 @@
+package service
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/messagepool"
+	"github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/filecoin-project/lotus/node/config"
+)
+
+var MpoolCmd = &cli.Command{
+	Name:  "mpool",
+	Usage: "Manage pending messages sent from local addresses",
+	Subcommands: []*cli.Command{
+		mpoolReplace,
+		mpoolCancel,
+	},
+}
+
+var mpoolReplace = &cli.Command{
+	Name:  "replace",
+	Usage: "Replace a pending message with one paying a higher fee",
+	Description: `Without flags the gas premium and fee cap are bumped by the minimum
+   replace-by-fee ratio. With --auto the fees are re-estimated, or they can be
+   set explicitly with --gas-premium and --gas-feecap.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "gas-feecap",
+			Usage: "gas feecap for new message (burn and pay to miner, attoFIL/GasUnit)",
+		},
+		&cli.StringFlag{
+			Name:  "gas-premium",
+			Usage: "gas price for new message (pay to miner, attoFIL/GasUnit)",
+		},
+		&cli.Int64Flag{
+			Name:  "gas-limit",
+			Usage: "gas limit for new message (GasUnit)",
+		},
+		&cli.BoolFlag{
+			Name:  "auto",
+			Usage: "automatically reprice the specified message",
+		},
+		&cli.StringFlag{
+			Name:  "fee-limit",
+			Usage: "Spend up to X FIL for this message in units of FIL. Applicable for auto mode",
+		},
+	},
+	ArgsUsage: "<from> <nonce> | <message-cid>",
+	Action: func(cctx *cli.Context) error {
+		srv, err := NewLotusService(cctx)
+		if err != nil {
+			return err
+		}
+		defer srv.Close() //nolint:errcheck
+
+		ctx := lcli.ReqContext(cctx)
+		afmt := lcli.NewAppFmt(cctx.App)
+
+		found, err := srv.pendingFromArgs(ctx, cctx)
+		if err != nil {
+			return err
+		}
+		msg := found.Message
+		ratio := srv.ReplaceByFeeRatio(ctx)
+		minRBF := messagepool.ComputeRBF(msg.GasPremium, ratio)
+
+		switch {
+		case cctx.Bool("auto"):
+			var mss *api.MessageSendSpec
+			if cctx.IsSet("fee-limit") {
+				maxFee, err := types.ParseFIL(cctx.String("fee-limit"))
+				if err != nil {
+					return xerrors.Errorf("parsing fee-limit: %w", err)
+				}
+				mss = &api.MessageSendSpec{
+					MaxFee: abi.TokenAmount(maxFee),
+				}
+			}
+
+			msg.GasFeeCap = abi.NewTokenAmount(0)
+			msg.GasPremium = abi.NewTokenAmount(0)
+			retm, err := srv.api.GasEstimateMessageGas(ctx, &msg, mss, types.EmptyTSK)
+			if err != nil {
+				return xerrors.Errorf("failed to estimate gas values: %w", err)
+			}
+
+			msg.GasPremium = big.Max(retm.GasPremium, minRBF)
+			msg.GasFeeCap = big.Max(retm.GasFeeCap, msg.GasPremium)
+
+			mff := func() (abi.TokenAmount, error) {
+				return abi.TokenAmount(config.DefaultDefaultMaxFee), nil
+			}
+			messagepool.CapGasFee(mff, &msg, mss)
+		case cctx.IsSet("gas-premium") || cctx.IsSet("gas-feecap"):
+			if cctx.IsSet("gas-premium") {
+				msg.GasPremium, err = types.BigFromString(cctx.String("gas-premium"))
+				if err != nil {
+					return xerrors.Errorf("parsing gas-premium: %w", err)
+				}
+			}
+			if cctx.IsSet("gas-feecap") {
+				msg.GasFeeCap, err = types.BigFromString(cctx.String("gas-feecap"))
+				if err != nil {
+					return xerrors.Errorf("parsing gas-feecap: %w", err)
+				}
+			}
+		default:
+			bumpFees(&msg, ratio)
+		}
+
+		if cctx.IsSet("gas-limit") {
+			msg.GasLimit = cctx.Int64("gas-limit")
+		}
+
+		if msg.GasPremium.LessThan(minRBF) {
+			log.Warnf("new gas premium %s is below the replace-by-fee minimum %s, the node will likely reject it", msg.GasPremium, minRBF)
+		}
+
+		smsg, err := srv.WalletSignMessage(ctx, msg.From, &msg)
+		if err != nil {
+			return xerrors.Errorf("failed to sign message: %w", err)
+		}
+
+		if err := srv.PushSigned(ctx, smsg); err != nil {
+			return xerrors.Errorf("failed to push new message to mempool: %w", err)
+		}
+
+		afmt.Println("new message cid: ", smsg.Cid())
+		return nil
+	},
+}
+
+var mpoolCancel = &cli.Command{
+	Name:      "cancel",
+	Usage:     "Cancel a pending message by replacing it with a zero value self-send",
+	ArgsUsage: "<from> <nonce> | <message-cid>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "yes",
+			Usage: "do not ask for confirmation",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		srv, err := NewLotusService(cctx)
+		if err != nil {
+			return err
+		}
+		defer srv.Close() //nolint:errcheck
+
+		ctx := lcli.ReqContext(cctx)
+		afmt := lcli.NewAppFmt(cctx.App)
+
+		found, err := srv.pendingFromArgs(ctx, cctx)
+		if err != nil {
+			return err
+		}
+
+		printMessageSummary(cctx.App.Writer, &found.Message)
+		if !cctx.Bool("yes") && !askUser(cctx.App.Writer, "Cancel this message? [yes/No]: ", false) {
+			return ErrAbortedByUser
+		}
+
+		// the replacement keeps the fees of the original bumped by the
+		// replace-by-fee ratio, only the gas limit is estimated again
+		fees := found.Message
+		bumpFees(&fees, srv.ReplaceByFeeRatio(ctx))
+
+		nonce := found.Message.Nonce
+		proto, err := srv.MessageForSend(ctx, lcli.SendParams{
+			From:       found.Message.From,
+			To:         found.Message.From,
+			Val:        big.Zero(),
+			Method:     builtin.MethodSend,
+			GasPremium: &fees.GasPremium,
+			GasFeeCap:  &fees.GasFeeCap,
+			Nonce:      &nonce,
+		})
+		if err != nil {
+			return xerrors.Errorf("creating message prototype: %w", err)
+		}
+
+		sm, _, err := srv.PublishMessage(ctx, proto, true)
+		if err != nil {
+			return xerrors.Errorf("publishing cancel message: %w", err)
+		}
+
+		afmt.Println("cancel message cid: ", sm.Cid())
+		return nil
+	},
+}
+
+// pendingFromArgs finds the pending message referenced by either a message
+// cid or a from address and nonce
+func (s *LotusService) pendingFromArgs(ctx context.Context, cctx *cli.Context) (*types.SignedMessage, error) {
+	var from address.Address
+	var nonce uint64
+	switch cctx.NArg() {
+	case 1:
+		mcid, err := cid.Decode(cctx.Args().First())
+		if err != nil {
+			return nil, err
+		}
+
+		msg, err := s.api.ChainGetMessage(ctx, mcid)
+		if err != nil {
+			return nil, xerrors.Errorf("could not find referenced message: %w", err)
+		}
+
+		from = msg.From
+		nonce = msg.Nonce
+	case 2:
+		f, err := address.NewFromString(cctx.Args().Get(0))
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := strconv.ParseUint(cctx.Args().Get(1), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		from = f
+		nonce = n
+	default:
+		return nil, lcli.IncorrectNumArgs(cctx)
+	}
+
+	return s.FindPending(ctx, from, nonce)
+}
+
+// FindPending returns the message in the mpool from addr with the given nonce
+func (s *LotusService) FindPending(ctx context.Context, from address.Address, nonce uint64) (*types.SignedMessage, error) {
+	pending, err := s.api.MpoolPending(ctx, types.EmptyTSK)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range pending {
+		if p.Message.From == from && p.Message.Nonce == nonce {
+			return p, nil
+		}
+	}
+	return nil, xerrors.Errorf("no pending message found from %s with nonce %d", from, nonce)
+}
+
+// ReplaceByFeeRatio returns the replace-by-fee ratio the node enforces
+func (s *LotusService) ReplaceByFeeRatio(ctx context.Context) types.Percent {
+	cfg, err := s.api.MpoolGetConfig(ctx)
+	if err != nil {
+		log.Warnf("failed to lookup the message pool config, using minimum replace-by-fee ratio: %s", err)
+		return messagepool.ReplaceByFeePercentageMinimum
+	}
+	return cfg.ReplaceByFeeRatio
+}
+
+// bumpFees raises the premium and fee cap of msg just enough to replace it
+func bumpFees(msg *types.Message, ratio types.Percent) {
+	msg.GasPremium = messagepool.ComputeRBF(msg.GasPremium, ratio)
+	msg.GasFeeCap = big.Max(messagepool.ComputeRBF(msg.GasFeeCap, ratio), msg.GasPremium)
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+)
+
+var MsgCmd = &cli.Command{
+	Name:  "msg",
+	Usage: "Inspect messages",
+	Subcommands: []*cli.Command{
+		msgInspect,
+	},
+}
+
+var msgInspect = &cli.Command{
+	Name:      "inspect",
+	Usage:     "Decode the params and, once executed, the return value of a message",
+	ArgsUsage: "<message cid>",
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+
+		mcid, err := cid.Decode(cctx.Args().First())
+		if err != nil {
+			return xerrors.Errorf("parsing message cid: %w", err)
+		}
+
+		srv, err := NewLotusService(cctx)
+		if err != nil {
+			return err
+		}
+		defer srv.Close() //nolint:errcheck
+
+		ctx := lcli.ReqContext(cctx)
+		w := cctx.App.Writer
+
+		msg, err := srv.api.ChainGetMessage(ctx, mcid)
+		if err != nil {
+			return xerrors.Errorf("getting message: %w", err)
+		}
+
+		printMessageSummary(w, msg)
+
+		if meta, err := srv.methodMeta(ctx, msg.To, msg.Method); err != nil {
+			fmt.Fprintf(w, "Method Name: unknown (%s)\n", err)
+		} else {
+			fmt.Fprintf(w, "Method Name: %s\n", meta.Name)
+		}
+
+		if len(msg.Params) > 0 {
+			params, err := srv.DecodeParamsToJSON(ctx, msg.To, msg.Method, msg.Params)
+			if err != nil {
+				fmt.Fprintf(w, "Decoded:     failed: %s\n", err)
+			} else {
+				fmt.Fprintf(w, "Decoded:     %s\n", params)
+			}
+		}
+
+		lookup, err := srv.api.StateSearchMsg(ctx, types.EmptyTSK, mcid, api.LookbackNoLimit, true)
+		if err != nil {
+			return xerrors.Errorf("searching message: %w", err)
+		}
+
+		fmt.Fprintln(w)
+		if lookup == nil {
+			fmt.Fprintln(w, "Status:      not executed")
+			return nil
+		}
+
+		fmt.Fprintf(w, "Status:      executed at %d in %s\n", lookup.Height, lookup.TipSet)
+		if lookup.Message != mcid {
+			// the receipt belongs to the message that replaced this one
+			fmt.Fprintf(w, "Replaced By: %s\n", lookup.Message)
+			if msg, err = srv.api.ChainGetMessage(ctx, lookup.Message); err != nil {
+				return xerrors.Errorf("getting replacing message: %w", err)
+			}
+		}
+		fmt.Fprintf(w, "Exit Code:   %d (%s)\n", lookup.Receipt.ExitCode, lookup.Receipt.ExitCode)
+		fmt.Fprintf(w, "Gas Used:    %d\n", lookup.Receipt.GasUsed)
+
+		if len(lookup.Receipt.Return) > 0 {
+			ret, err := srv.DecodeReturnToJSON(ctx, msg.To, msg.Method, lookup.Receipt.Return)
+			if err != nil {
+				fmt.Fprintf(w, "Return:      %x (%s)\n", lookup.Receipt.Return, err)
+			} else {
+				fmt.Fprintf(w, "Return:      %s\n", ret)
+			}
+		}
+		return nil
+	},
+}
@@ -0,0 +1,699 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	init11 "github.com/filecoin-project/go-state-types/builtin/v11/init"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/multisig"
+	"github.com/filecoin-project/lotus/chain/store"
+	"github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/filecoin-project/lotus/lib/tablewriter"
+)
+
+var MsigCmd = &cli.Command{
+	Name:  "msig",
+	Usage: "Interact with multisig wallets using signer keys held by this tool",
+	Subcommands: []*cli.Command{
+		msigCreate,
+		msigInspect,
+		msigPropose,
+		msigApprove,
+		msigCancel,
+		msigBundleUpdate,
+		msigPushApprovals,
+	},
+}
+
+var msigCreate = &cli.Command{
+	Name:      "create",
+	Usage:     "Create a new multisig wallet",
+	ArgsUsage: "<signer address> [...signer address]",
+	Flags: append([]cli.Flag{
+		&cli.Uint64Flag{
+			Name:  "required",
+			Usage: "number of approvals required, default: all signers",
+		},
+		&cli.StringFlag{
+			Name:  "value",
+			Usage: "initial funds to give to the multisig (FIL)",
+			Value: "0",
+		},
+		&cli.Int64Flag{
+			Name:  "duration",
+			Usage: "length of the vesting period in epochs, starting now",
+		},
+		&cli.StringFlag{
+			Name:  "from",
+			Usage: "account to send the create message from, default: wallet default",
+		},
+		&cli.BoolFlag{
+			Name:  "yes",
+			Usage: "do not ask for confirmation",
+		},
+	}, submitFlags...),
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() < 1 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+
+		var signers []address.Address
+		for _, s := range cctx.Args().Slice() {
+			a, err := address.NewFromString(s)
+			if err != nil {
+				return xerrors.Errorf("parsing signer address %q: %w", s, err)
+			}
+			signers = append(signers, a)
+		}
+
+		required := cctx.Uint64("required")
+		if required == 0 {
+			required = uint64(len(signers))
+		}
+		if required > uint64(len(signers)) {
+			return xerrors.Errorf("%d approvals required but only %d signers given", required, len(signers))
+		}
+
+		val, err := types.ParseFIL(cctx.String("value"))
+		if err != nil {
+			return xerrors.Errorf("parsing value: %w", err)
+		}
+
+		srv, err := NewLotusService(cctx)
+		if err != nil {
+			return err
+		}
+		defer srv.Close() //nolint:errcheck
+
+		ctx := lcli.ReqContext(cctx)
+		w := cctx.App.Writer
+
+		mb, err := srv.msigBuilder(ctx, cctx, cctx.String("from"))
+		if err != nil {
+			return err
+		}
+
+		var start abi.ChainEpoch
+		if cctx.Int64("duration") > 0 {
+			head, err := srv.api.ChainHead(ctx)
+			if err != nil {
+				return err
+			}
+			start = head.Height()
+		}
+
+		msg, err := mb.Create(signers, required, start, abi.ChainEpoch(cctx.Int64("duration")), abi.TokenAmount(val))
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "Creating a %d of %d multisig with %s\n", required, len(signers), types.FIL(val))
+		fmt.Fprintf(w, "Signers: %s\n", addrList(signers))
+		if !confirmSubmit(cctx, "Create the multisig? [yes/No]: ") {
+			return ErrAbortedByUser
+		}
+
+		lookup, err := SubmitMessage(ctx, cctx, srv, &api.MessagePrototype{Message: *msg})
+		if err != nil || lookup == nil {
+			return err
+		}
+
+		var ret init11.ExecReturn
+		if err := ret.UnmarshalCBOR(bytes.NewReader(lookup.Receipt.Return)); err != nil {
+			return xerrors.Errorf("decoding create return: %w", err)
+		}
+		fmt.Fprintf(w, "Created new multisig: %s %s\n", ret.IDAddress, ret.RobustAddress)
+		return nil
+	},
+}
+
+var msigInspect = &cli.Command{
+	Name:      "inspect",
+	Usage:     "Show a multisig wallet and its pending transactions",
+	ArgsUsage: "<multisig address>",
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+
+		maddr, err := address.NewFromString(cctx.Args().First())
+		if err != nil {
+			return xerrors.Errorf("parsing multisig address: %w", err)
+		}
+
+		srv, err := NewLotusService(cctx)
+		if err != nil {
+			return err
+		}
+		defer srv.Close() //nolint:errcheck
+
+		ctx := lcli.ReqContext(cctx)
+		w := cctx.App.Writer
+
+		act, mstate, err := srv.loadMsig(ctx, maddr)
+		if err != nil {
+			return err
+		}
+
+		head, err := srv.api.ChainHead(ctx)
+		if err != nil {
+			return err
+		}
+		locked, err := mstate.LockedBalance(head.Height())
+		if err != nil {
+			return err
+		}
+		threshold, err := mstate.Threshold()
+		if err != nil {
+			return err
+		}
+		signers, err := mstate.Signers()
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "Balance:   %s\n", types.FIL(act.Balance))
+		fmt.Fprintf(w, "Spendable: %s\n", types.FIL(big.Sub(act.Balance, locked)))
+		fmt.Fprintf(w, "Threshold: %d / %d\n", threshold, len(signers))
+		fmt.Fprintln(w, "Signers:")
+
+		tw := tablewriter.New(
+			tablewriter.Col("ID"),
+			tablewriter.Col("Address"),
+			tablewriter.Col("Local"))
+		for _, s := range signers {
+			row := map[string]interface{}{"ID": s}
+			if key, err := srv.keyAddress(ctx, s); err == nil {
+				row["Address"] = key
+				if has, err := srv.wallet.WalletHas(ctx, key); err == nil && has {
+					row["Local"] = "yes"
+				}
+			}
+			tw.Write(row)
+		}
+		if err := tw.Flush(w); err != nil {
+			return err
+		}
+
+		txns, err := pendingTxns(mstate)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "\nTransactions: %d\n", len(txns))
+		for _, t := range txns {
+			fmt.Fprintln(w)
+			srv.printTxn(ctx, w, t.id, t.txn, threshold)
+		}
+		return nil
+	},
+}
+
+var msigPropose = &cli.Command{
+	Name:      "propose",
+	Usage:     "Propose a multisig transaction",
+	ArgsUsage: "<multisig address> <destination address> <value (FIL)>",
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:  "method",
+			Usage: "method to invoke on the destination by number or name",
+			Value: "0",
+		},
+		&cli.StringFlag{
+			Name:  "params-json",
+			Usage: "params of the invoked method in json",
+		},
+		&cli.StringFlag{
+			Name:  "params-hex",
+			Usage: "params of the invoked method in hex",
+		},
+		&cli.StringFlag{
+			Name:  "from",
+			Usage: "signer to propose from, default: the first signer in the local wallet",
+		},
+		&cli.StringFlag{
+			Name:  "out",
+			Usage: "write a proposal bundle for offline signers to this file",
+		},
+		&cli.BoolFlag{
+			Name:  "yes",
+			Usage: "do not ask for confirmation",
+		},
+	}, submitFlags...),
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 3 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+
+		maddr, err := address.NewFromString(cctx.Args().Get(0))
+		if err != nil {
+			return xerrors.Errorf("parsing multisig address: %w", err)
+		}
+		to, err := address.NewFromString(cctx.Args().Get(1))
+		if err != nil {
+			return xerrors.Errorf("parsing destination address: %w", err)
+		}
+		val, err := types.ParseFIL(cctx.Args().Get(2))
+		if err != nil {
+			return xerrors.Errorf("parsing value: %w", err)
+		}
+
+		srv, err := NewLotusService(cctx)
+		if err != nil {
+			return err
+		}
+		defer srv.Close() //nolint:errcheck
+
+		ctx := lcli.ReqContext(cctx)
+		w := cctx.App.Writer
+
+		method, params, err := srv.methodAndParams(ctx, cctx, to)
+		if err != nil {
+			return err
+		}
+
+		from, err := srv.msigSigner(ctx, cctx, maddr, nil)
+		if err != nil {
+			return err
+		}
+		mb, err := srv.msigBuilder(ctx, cctx, from.String())
+		if err != nil {
+			return err
+		}
+
+		msg, err := mb.Propose(maddr, to, abi.TokenAmount(val), method, params)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "Proposing from %s on %s:\n", from, maddr)
+		srv.printTxn(ctx, w, -1, multisig.Transaction{To: to, Value: abi.TokenAmount(val), Method: method, Params: params}, 0)
+		if !confirmSubmit(cctx, "Propose the transaction? [yes/No]: ") {
+			return ErrAbortedByUser
+		}
+
+		var bundle *MsigBundle
+		if cctx.String("out") != "" && !cctx.Bool("dry-run") {
+			requester, err := srv.api.StateLookupID(ctx, from, types.EmptyTSK)
+			if err != nil {
+				return xerrors.Errorf("looking up proposer %s: %w", from, err)
+			}
+			bundle, err = srv.NewMsigBundle(ctx, maddr, multisig.ProposalHashData{
+				Requester: requester,
+				To:        to,
+				Value:     abi.TokenAmount(val),
+				Method:    method,
+				Params:    params,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		lookup, err := SubmitMessage(ctx, cctx, srv, &api.MessagePrototype{Message: *msg})
+		if err != nil {
+			return err
+		}
+		if lookup == nil {
+			if bundle == nil {
+				return nil
+			}
+			if err := WriteMsigBundle(cctx.String("out"), bundle); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "Proposal bundle written to %s, run 'msig bundle-update' on it once the proposal is executed\n", cctx.String("out"))
+			return nil
+		}
+
+		var ret multisig.ProposeReturn
+		if err := ret.UnmarshalCBOR(bytes.NewReader(lookup.Receipt.Return)); err != nil {
+			return xerrors.Errorf("decoding propose return: %w", err)
+		}
+		fmt.Fprintf(w, "Transaction ID: %d\n", ret.TxnID)
+		if ret.Applied {
+			fmt.Fprintf(w, "Transaction was executed with exit code %d (%s)\n", ret.Code, ret.Code)
+			return nil
+		}
+
+		if bundle == nil {
+			return nil
+		}
+		txid := int64(ret.TxnID)
+		bundle.TxnID = &txid
+		if err := srv.updateMsigBundle(ctx, w, bundle); err != nil {
+			return err
+		}
+		if err := WriteMsigBundle(cctx.String("out"), bundle); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Proposal bundle with %d approval(s) written to %s\n", len(bundle.Approvals), cctx.String("out"))
+		return nil
+	},
+}
+
+var msigApprove = &cli.Command{
+	Name:      "approve",
+	Usage:     "Approve a pending multisig transaction",
+	ArgsUsage: "<multisig address> <transaction id> | --bundle <bundle file>",
+	Description: `With --bundle the approval prepared in a proposal bundle for a signer in the
+   local wallet is signed without network access, after checking it matches the
+   proposal hash. Collect the signed approvals with 'msig push-approvals'.`,
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:  "from",
+			Usage: "signer to approve with, default: the first signer in the local wallet which didn't approve yet",
+		},
+		&cli.StringFlag{
+			Name:  "bundle",
+			Usage: "sign the approval from a proposal bundle offline",
+		},
+		&cli.Uint64Flag{
+			Name:  "nonce",
+			Usage: "with --bundle, override the nonce of the prepared approval",
+		},
+		&cli.StringFlag{
+			Name:  "out",
+			Usage: "with --bundle, write the signed approval to this path ('-' for stdout)",
+			Value: "-",
+		},
+		&cli.BoolFlag{
+			Name:  "yes",
+			Usage: "do not ask for confirmation",
+		},
+	}, submitFlags...),
+	Action: func(cctx *cli.Context) error {
+		if cctx.IsSet("bundle") {
+			return msigApproveBundle(cctx)
+		}
+		return msigTxnAction(cctx, false)
+	},
+}
+
+var msigCancel = &cli.Command{
+	Name:      "cancel",
+	Usage:     "Cancel a pending multisig transaction, only the proposer can cancel",
+	ArgsUsage: "<multisig address> <transaction id>",
+	Flags: append([]cli.Flag{
+		&cli.BoolFlag{
+			Name:  "yes",
+			Usage: "do not ask for confirmation",
+		},
+	}, submitFlags...),
+	Action: func(cctx *cli.Context) error {
+		return msigTxnAction(cctx, true)
+	},
+}
+
+// msigTxnAction approves or cancels a pending transaction. The proposal hash
+// is computed from the transaction shown, so the message only applies to it.
+func msigTxnAction(cctx *cli.Context, cancel bool) error {
+	if cctx.NArg() != 2 {
+		return lcli.IncorrectNumArgs(cctx)
+	}
+
+	maddr, err := address.NewFromString(cctx.Args().Get(0))
+	if err != nil {
+		return xerrors.Errorf("parsing multisig address: %w", err)
+	}
+	txid, err := strconv.ParseUint(cctx.Args().Get(1), 10, 64)
+	if err != nil {
+		return xerrors.Errorf("parsing transaction id: %w", err)
+	}
+
+	srv, err := NewLotusService(cctx)
+	if err != nil {
+		return err
+	}
+	defer srv.Close() //nolint:errcheck
+
+	ctx := lcli.ReqContext(cctx)
+	w := cctx.App.Writer
+
+	_, mstate, err := srv.loadMsig(ctx, maddr)
+	if err != nil {
+		return err
+	}
+	txn, err := findTxn(mstate, txid)
+	if err != nil {
+		return err
+	}
+	threshold, err := mstate.Threshold()
+	if err != nil {
+		return err
+	}
+
+	var from address.Address
+	if cancel {
+		if from, err = srv.SenderKey(ctx, cctx, txn.Approved[0]); err != nil {
+			return xerrors.Errorf("only the proposer %s can cancel: %w", txn.Approved[0], err)
+		}
+	} else {
+		if from, err = srv.msigSigner(ctx, cctx, maddr, txn.Approved); err != nil {
+			return err
+		}
+	}
+
+	mb, err := srv.msigBuilder(ctx, cctx, from.String())
+	if err != nil {
+		return err
+	}
+
+	hash := &multisig.ProposalHashData{
+		Requester: txn.Approved[0],
+		To:        txn.To,
+		Value:     txn.Value,
+		Method:    txn.Method,
+		Params:    txn.Params,
+	}
+
+	srv.printTxn(ctx, w, int64(txid), txn, threshold)
+
+	var msg *types.Message
+	if cancel {
+		if !confirmSubmit(cctx, fmt.Sprintf("Cancel transaction %d as %s? [yes/No]: ", txid, from)) {
+			return ErrAbortedByUser
+		}
+		msg, err = mb.Cancel(maddr, txid, hash)
+	} else {
+		if !confirmSubmit(cctx, fmt.Sprintf("Approve transaction %d as %s? [yes/No]: ", txid, from)) {
+			return ErrAbortedByUser
+		}
+		msg, err = mb.Approve(maddr, txid, hash)
+	}
+	if err != nil {
+		return err
+	}
+
+	lookup, err := SubmitMessage(ctx, cctx, srv, &api.MessagePrototype{Message: *msg})
+	if err != nil || lookup == nil || cancel {
+		return err
+	}
+
+	var ret multisig.ApproveReturn
+	if err := ret.UnmarshalCBOR(bytes.NewReader(lookup.Receipt.Return)); err != nil {
+		return xerrors.Errorf("decoding approve return: %w", err)
+	}
+	if ret.Applied {
+		fmt.Fprintf(w, "Transaction was executed with exit code %d (%s)\n", ret.Code, ret.Code)
+	}
+	return nil
+}
+
+// msigBuilder returns the multisig message builder for the network's actors
+// version, sending from the given address or the wallet default
+func (s *LotusService) msigBuilder(ctx context.Context, cctx *cli.Context, from string) (multisig.MessageBuilder, error) {
+	var sender address.Address
+	if from == "" {
+		def, err := s.DefaultFrom(ctx)
+		if err != nil {
+			return nil, err
+		}
+		sender = def
+	} else {
+		a, err := address.NewFromString(from)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing from address: %w", err)
+		}
+		if sender, err = s.SenderKey(ctx, cctx, a); err != nil {
+			return nil, err
+		}
+	}
+
+	av, err := s.ActorsVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return multisig.Message(av, sender), nil
+}
+
+// msigSigner returns the key of the signer to send from: --from if set,
+// otherwise the first signer in the local wallet which isn't in exclude
+func (s *LotusService) msigSigner(ctx context.Context, cctx *cli.Context, maddr address.Address, exclude []address.Address) (address.Address, error) {
+	_, mstate, err := s.loadMsig(ctx, maddr)
+	if err != nil {
+		return address.Undef, err
+	}
+	signers, err := mstate.Signers()
+	if err != nil {
+		return address.Undef, err
+	}
+
+	skip := map[address.Address]bool{}
+	for _, a := range exclude {
+		skip[a] = true
+	}
+
+	if f := cctx.String("from"); f != "" {
+		from, err := address.NewFromString(f)
+		if err != nil {
+			return address.Undef, xerrors.Errorf("parsing from address: %w", err)
+		}
+		id, err := s.api.StateLookupID(ctx, from, types.EmptyTSK)
+		if err != nil {
+			return address.Undef, xerrors.Errorf("looking up %s: %w", from, err)
+		}
+		if !containsAddr(signers, id) {
+			return address.Undef, xerrors.Errorf("%s is not a signer of %s", from, maddr)
+		}
+		if skip[id] {
+			return address.Undef, xerrors.Errorf("%s already approved", from)
+		}
+		return s.SenderKey(ctx, cctx, id)
+	}
+
+	for _, signer := range signers {
+		if skip[signer] {
+			continue
+		}
+		if key, err := s.LocalSigner(ctx, signer); err == nil {
+			return key, nil
+		}
+	}
+	return address.Undef, xerrors.Errorf("no signer of %s left to approve is in the local wallet, select one with --from", maddr)
+}
+
+func (s *LotusService) loadMsig(ctx context.Context, maddr address.Address) (*types.Actor, multisig.State, error) {
+	act, err := s.api.StateGetActor(ctx, maddr, types.EmptyTSK)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getting multisig actor: %w", err)
+	}
+
+	mstate, err := multisig.Load(store.ActorStore(ctx, blockstore.NewAPIBlockstore(s.api)), act)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("loading multisig state of %s: %w", maddr, err)
+	}
+	return act, mstate, nil
+}
+
+// methodAndParams reads --method and --params-json or --params-hex for a
+// message to the actor at to
+func (s *LotusService) methodAndParams(ctx context.Context, cctx *cli.Context, to address.Address) (abi.MethodNum, []byte, error) {
+	method, err := s.ResolveMethod(ctx, to, cctx.String("method"))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	switch {
+	case cctx.IsSet("params-json") && cctx.IsSet("params-hex"):
+		return 0, nil, xerrors.Errorf("can only specify one of 'params-json' and 'params-hex'")
+	case cctx.IsSet("params-json"):
+		params, err := s.DecodeTypedParamsFromJSON(ctx, to, method, cctx.String("params-json"))
+		if err != nil {
+			return 0, nil, xerrors.Errorf("failed to decode json params: %w", err)
+		}
+		return method, params, nil
+	case cctx.IsSet("params-hex"):
+		params, err := hex.DecodeString(cctx.String("params-hex"))
+		if err != nil {
+			return 0, nil, xerrors.Errorf("failed to decode hex params: %w", err)
+		}
+		return method, params, nil
+	}
+	return method, nil, nil
+}
+
+type pendingTxn struct {
+	id  int64
+	txn multisig.Transaction
+}
+
+func pendingTxns(mstate multisig.State) ([]pendingTxn, error) {
+	var txns []pendingTxn
+	err := mstate.ForEachPendingTxn(func(id int64, txn multisig.Transaction) error {
+		txns = append(txns, pendingTxn{id, txn})
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("reading pending transactions: %w", err)
+	}
+	sort.Slice(txns, func(i, j int) bool { return txns[i].id < txns[j].id })
+	return txns, nil
+}
+
+func findTxn(mstate multisig.State, txid uint64) (multisig.Transaction, error) {
+	txns, err := pendingTxns(mstate)
+	if err != nil {
+		return multisig.Transaction{}, err
+	}
+	for _, t := range txns {
+		if t.id == int64(txid) {
+			return t.txn, nil
+		}
+	}
+	return multisig.Transaction{}, xerrors.Errorf("no pending transaction with id %d", txid)
+}
+
+// printTxn prints a transaction with its method and params decoded, id is -1
+// and threshold 0 for a transaction not proposed yet
+func (s *LotusService) printTxn(ctx context.Context, w io.Writer, id int64, txn multisig.Transaction, threshold uint64) {
+	if id >= 0 {
+		fmt.Fprintf(w, "Transaction %d\n", id)
+	}
+	fmt.Fprintf(w, "  To:       %s\n", txn.To)
+	fmt.Fprintf(w, "  Value:    %s\n", types.FIL(txn.Value))
+
+	name := "Send"
+	if txn.Method != 0 {
+		name = "unknown"
+		if meta, err := s.methodMeta(ctx, txn.To, txn.Method); err == nil {
+			name = meta.Name
+		}
+	}
+	fmt.Fprintf(w, "  Method:   %s (%d)\n", name, txn.Method)
+
+	if len(txn.Params) > 0 {
+		params, err := s.DecodeParamsToJSON(ctx, txn.To, txn.Method, txn.Params)
+		if err != nil {
+			fmt.Fprintf(w, "  Params:   %x (%s)\n", txn.Params, err)
+		} else {
+			fmt.Fprintf(w, "  Params:   %s\n", params)
+		}
+	}
+
+	if threshold > 0 {
+		fmt.Fprintf(w, "  Approved: %d / %d by %s\n", len(txn.Approved), threshold, addrList(txn.Approved))
+	}
+}
+
+func containsAddr(addrs []address.Address, a address.Address) bool {
+	for _, x := range addrs {
+		if x == a {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,476 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/builtin"
+	msig11 "github.com/filecoin-project/go-state-types/builtin/v11/multisig"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/multisig"
+	"github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+)
+
+const (
+	MsigBundleVersion = 1
+	MsigBundleKind    = "msig-proposal"
+)
+
+// MsigBundle carries a multisig proposal to signers on offline machines. The
+// proposal hash binds approvals to the proposed transaction, signers recompute
+// it from Proposal before signing one of the prepared approval messages.
+// Summary only exists for human review.
+type MsigBundle struct {
+	Version      int                       `json:"version"`
+	Kind         string                    `json:"kind"`
+	Msig         address.Address           `json:"msig"`
+	TxnID        *int64                    `json:"txn_id,omitempty"`
+	Proposal     multisig.ProposalHashData `json:"proposal"`
+	ProposalHash string                    `json:"proposal_hash"`
+	Summary      MsigBundleSummary         `json:"summary"`
+	Approvals    []types.Message           `json:"approvals,omitempty"`
+}
+
+type MsigBundleSummary struct {
+	Value  string `json:"value"`
+	Method string `json:"method"`
+	Params string `json:"params,omitempty"`
+}
+
+func proposalHash(p *multisig.ProposalHashData) ([]byte, error) {
+	ser, err := p.Serialize()
+	if err != nil {
+		return nil, xerrors.Errorf("serializing proposal: %w", err)
+	}
+	h := blake2b.Sum256(ser)
+	return h[:], nil
+}
+
+func (s *LotusService) NewMsigBundle(ctx context.Context, maddr address.Address, p multisig.ProposalHashData) (*MsigBundle, error) {
+	hash, err := proposalHash(&p)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := MsigBundleSummary{
+		Value:  types.FIL(p.Value).String(),
+		Method: fmt.Sprintf("Send (%d)", p.Method),
+	}
+	if p.Method != 0 {
+		summary.Method = fmt.Sprintf("unknown (%d)", p.Method)
+		if meta, err := s.methodMeta(ctx, p.To, p.Method); err == nil {
+			summary.Method = fmt.Sprintf("%s (%d)", meta.Name, p.Method)
+		}
+	}
+	if len(p.Params) > 0 {
+		if params, err := s.DecodeParamsToJSON(ctx, p.To, p.Method, p.Params); err == nil {
+			summary.Params = params
+		}
+	}
+
+	return &MsigBundle{
+		Version:      MsigBundleVersion,
+		Kind:         MsigBundleKind,
+		Msig:         maddr,
+		Proposal:     p,
+		ProposalHash: hex.EncodeToString(hash),
+		Summary:      summary,
+	}, nil
+}
+
+func WriteMsigBundle(path string, b *MsigBundle) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	// bundle-update rewrites the bundle in place
+	return replaceFile(path, data)
+}
+
+// ReadMsigBundle reads a bundle and checks its proposal hash
+func ReadMsigBundle(path string) (*MsigBundle, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var b MsigBundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, xerrors.Errorf("decoding msig bundle: %w", err)
+	}
+	if b.Version != MsigBundleVersion {
+		return nil, xerrors.Errorf("unsupported msig bundle version %d", b.Version)
+	}
+	if b.Kind != MsigBundleKind {
+		return nil, xerrors.Errorf("expected a %s file, got %s", MsigBundleKind, b.Kind)
+	}
+
+	hash, err := proposalHash(&b.Proposal)
+	if err != nil {
+		return nil, err
+	}
+	if hex.EncodeToString(hash) != b.ProposalHash {
+		return nil, xerrors.Errorf("proposal hash mismatch: bundle has %s, proposal hashes to %x", b.ProposalHash, hash)
+	}
+	return &b, nil
+}
+
+// checkApproval checks msg approves the bundled proposal and nothing else
+func (b *MsigBundle) checkApproval(msg *types.Message) error {
+	if b.TxnID == nil {
+		return xerrors.Errorf("bundle has no transaction id yet")
+	}
+	if msg.To != b.Msig {
+		return xerrors.Errorf("approval is sent to %s, not the multisig %s", msg.To, b.Msig)
+	}
+	if msg.Method != builtin.MethodsMultisig.Approve {
+		return xerrors.Errorf("approval invokes method %d, not Approve", msg.Method)
+	}
+	if !msg.Value.IsZero() {
+		return xerrors.Errorf("approval transfers %s", types.FIL(msg.Value))
+	}
+
+	var params msig11.TxnIDParams
+	if err := params.UnmarshalCBOR(bytes.NewReader(msg.Params)); err != nil {
+		return xerrors.Errorf("decoding approval params: %w", err)
+	}
+	if int64(params.ID) != *b.TxnID {
+		return xerrors.Errorf("approval is for transaction %d, bundle is for %d", params.ID, *b.TxnID)
+	}
+	if hex.EncodeToString(params.ProposalHash) != b.ProposalHash {
+		return xerrors.Errorf("approval proposal hash %x doesn't match the bundle", params.ProposalHash)
+	}
+	return nil
+}
+
+func (b *MsigBundle) print(w io.Writer) {
+	fmt.Fprintf(w, "Multisig:      %s\n", b.Msig)
+	if b.TxnID != nil {
+		fmt.Fprintf(w, "Transaction:   %d\n", *b.TxnID)
+	}
+	fmt.Fprintf(w, "Proposer:      %s\n", b.Proposal.Requester)
+	fmt.Fprintf(w, "To:            %s\n", b.Proposal.To)
+	fmt.Fprintf(w, "Value:         %s\n", types.FIL(b.Proposal.Value))
+	fmt.Fprintf(w, "Method:        %d (%s)\n", b.Proposal.Method, b.Summary.Method)
+	if len(b.Proposal.Params) > 0 {
+		fmt.Fprintf(w, "Params:        %x\n", b.Proposal.Params)
+		if b.Summary.Params != "" {
+			fmt.Fprintf(w, "Params (JSON): %s\n", b.Summary.Params)
+		}
+	}
+	fmt.Fprintf(w, "Proposal Hash: %s\n", b.ProposalHash)
+}
+
+// updateMsigBundle looks up the transaction id of the bundled proposal and
+// prepares an approval message for every signer which hasn't approved yet
+func (s *LotusService) updateMsigBundle(ctx context.Context, w io.Writer, b *MsigBundle) error {
+	_, mstate, err := s.loadMsig(ctx, b.Msig)
+	if err != nil {
+		return err
+	}
+
+	txns, err := pendingTxns(mstate)
+	if err != nil {
+		return err
+	}
+
+	var txn *pendingTxn
+	for i, t := range txns {
+		if b.TxnID != nil && t.id != *b.TxnID {
+			continue
+		}
+		hash, err := proposalHash(&multisig.ProposalHashData{
+			Requester: t.txn.Approved[0],
+			To:        t.txn.To,
+			Value:     t.txn.Value,
+			Method:    t.txn.Method,
+			Params:    t.txn.Params,
+		})
+		if err != nil {
+			return err
+		}
+		if hex.EncodeToString(hash) == b.ProposalHash {
+			txn = &txns[i]
+			break
+		}
+	}
+	if txn == nil {
+		if b.TxnID != nil {
+			return xerrors.Errorf("transaction %d of %s is not pending or doesn't match the bundle", *b.TxnID, b.Msig)
+		}
+		return xerrors.Errorf("no pending transaction of %s matches the bundle, was the proposal executed yet?", b.Msig)
+	}
+	b.TxnID = &txn.id
+
+	signers, err := mstate.Signers()
+	if err != nil {
+		return err
+	}
+	av, err := s.ActorsVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	// any approval may be the last one executing the transaction
+	inner, err := s.api.GasEstimateGasLimit(ctx, &types.Message{
+		From:   b.Msig,
+		To:     b.Proposal.To,
+		Value:  b.Proposal.Value,
+		Method: b.Proposal.Method,
+		Params: b.Proposal.Params,
+	}, types.EmptyTSK)
+	if err != nil {
+		fmt.Fprintf(w, "Warning: estimating gas of the transaction failed, approvals may run out of gas executing it: %s\n", err)
+		inner = 0
+	}
+
+	b.Approvals = nil
+	for _, signer := range signers {
+		if containsAddr(txn.txn.Approved, signer) {
+			continue
+		}
+		key, err := s.keyAddress(ctx, signer)
+		if err != nil {
+			fmt.Fprintf(w, "Skipping signer %s: %s\n", signer, err)
+			continue
+		}
+
+		msg, err := multisig.Message(av, key).Approve(b.Msig, uint64(txn.id), &b.Proposal)
+		if err != nil {
+			return err
+		}
+		filled, err := s.FillMessage(ctx, &api.MessagePrototype{Message: *msg})
+		if err != nil {
+			return xerrors.Errorf("preparing approval of %s: %w", key, err)
+		}
+		filled.GasLimit += inner
+		b.Approvals = append(b.Approvals, *filled)
+	}
+	return nil
+}
+
+var msigBundleUpdate = &cli.Command{
+	Name:      "bundle-update",
+	Usage:     "Fill the transaction id and approval messages of a proposal bundle once the proposal is on chain",
+	ArgsUsage: "<bundle file>",
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+
+		path := cctx.Args().First()
+		b, err := ReadMsigBundle(path)
+		if err != nil {
+			return err
+		}
+
+		srv, err := NewLotusService(cctx)
+		if err != nil {
+			return err
+		}
+		defer srv.Close() //nolint:errcheck
+
+		ctx := lcli.ReqContext(cctx)
+		w := cctx.App.Writer
+
+		if err := srv.updateMsigBundle(ctx, w, b); err != nil {
+			return err
+		}
+		if err := WriteMsigBundle(path, b); err != nil {
+			return err
+		}
+		if path != "-" {
+			fmt.Fprintf(w, "Transaction %d, %d approval(s) prepared in %s\n", *b.TxnID, len(b.Approvals), path)
+		}
+		return nil
+	},
+}
+
+// msigApproveBundle signs the prepared approval of a local signer without
+// network access
+func msigApproveBundle(cctx *cli.Context) error {
+	if cctx.NArg() != 0 {
+		return lcli.IncorrectNumArgs(cctx)
+	}
+
+	b, err := ReadMsigBundle(cctx.String("bundle"))
+	if err != nil {
+		return err
+	}
+	if b.TxnID == nil || len(b.Approvals) == 0 {
+		return xerrors.Errorf("bundle has no approvals prepared, run 'msig bundle-update' on the online host first")
+	}
+
+	srv, err := NewOfflineLotusService()
+	if err != nil {
+		return err
+	}
+
+	ctx := lcli.ReqContext(cctx)
+
+	var from address.Address
+	if f := cctx.String("from"); f != "" {
+		if from, err = address.NewFromString(f); err != nil {
+			return xerrors.Errorf("parsing from address: %w", err)
+		}
+	}
+
+	var msg *types.Message
+	for i := range b.Approvals {
+		a := &b.Approvals[i]
+		if from != address.Undef && a.From != from {
+			continue
+		}
+		if has, err := srv.wallet.WalletHas(ctx, a.From); err != nil || !has {
+			continue
+		}
+		if msg != nil {
+			return xerrors.Errorf("the local wallet holds keys of several signers, select one with --from")
+		}
+		msg = a
+	}
+	if msg == nil {
+		return xerrors.Errorf("no signer which still has to approve is in the local wallet")
+	}
+
+	if err := b.checkApproval(msg); err != nil {
+		return xerrors.Errorf("refusing to sign: %w", err)
+	}
+	if cctx.IsSet("nonce") {
+		msg.Nonce = cctx.Uint64("nonce")
+	}
+
+	b.print(os.Stderr)
+	fmt.Fprintln(os.Stderr)
+	printMessageSummary(os.Stderr, msg)
+	if !cctx.Bool("yes") && !askUser(os.Stderr, fmt.Sprintf("Approve transaction %d as %s? [yes/No]: ", *b.TxnID, msg.From), false) {
+		return ErrAbortedByUser
+	}
+
+	sm, err := srv.WalletSignMessage(ctx, msg.From, msg)
+	if err != nil {
+		return err
+	}
+	return WriteMessageFile(cctx.String("out"), MessageFileSigned, &sm.Message, &sm.Signature)
+}
+
+var msigPushApprovals = &cli.Command{
+	Name:      "push-approvals",
+	Usage:     "Push signed approvals collected from offline signers",
+	ArgsUsage: "<bundle file> <signed approval file> [...signed approval file]",
+	Description: `Checks every approval against the bundle and pushes them ordered by sender
+   and nonce. Approvals beyond the ones needed to reach the threshold are not
+   pushed, they would fail once the transaction is executed.`,
+	Flags: waitFlags,
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() < 2 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+
+		b, err := ReadMsigBundle(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		var sms []*types.SignedMessage
+		for _, path := range cctx.Args().Slice()[1:] {
+			mf, err := ReadMessageFile(path, MessageFileSigned)
+			if err != nil {
+				return xerrors.Errorf("%s: %w", path, err)
+			}
+			if err := b.checkApproval(&mf.Message); err != nil {
+				return xerrors.Errorf("%s: %w", path, err)
+			}
+			sms = append(sms, &types.SignedMessage{Message: mf.Message, Signature: *mf.Signature})
+		}
+		sort.Slice(sms, func(i, j int) bool {
+			if sms[i].Message.From != sms[j].Message.From {
+				return sms[i].Message.From.String() < sms[j].Message.From.String()
+			}
+			return sms[i].Message.Nonce < sms[j].Message.Nonce
+		})
+
+		srv, err := NewLotusService(cctx)
+		if err != nil {
+			return err
+		}
+		defer srv.Close() //nolint:errcheck
+
+		ctx := lcli.ReqContext(cctx)
+		w := cctx.App.Writer
+
+		_, mstate, err := srv.loadMsig(ctx, b.Msig)
+		if err != nil {
+			return err
+		}
+		txn, err := findTxn(mstate, uint64(*b.TxnID))
+		if err != nil {
+			return err
+		}
+		threshold, err := mstate.Threshold()
+		if err != nil {
+			return err
+		}
+
+		needed := int(threshold) - len(txn.Approved)
+		approvers := map[address.Address]bool{}
+		for _, a := range txn.Approved {
+			if key, err := srv.keyAddress(ctx, a); err == nil {
+				approvers[key] = true
+			}
+		}
+
+		var last *types.SignedMessage
+		for _, sm := range sms {
+			if needed <= 0 {
+				fmt.Fprintf(w, "Skipping %s from %s: threshold reached\n", sm.Cid(), sm.Message.From)
+				continue
+			}
+			if approvers[sm.Message.From] {
+				fmt.Fprintf(w, "Skipping %s: %s already approved\n", sm.Cid(), sm.Message.From)
+				continue
+			}
+
+			if err := srv.PushSigned(ctx, sm); err != nil {
+				return xerrors.Errorf("pushing approval from %s: %w", sm.Message.From, err)
+			}
+			fmt.Fprintf(w, "Pushed approval from %s: %s\n", sm.Message.From, sm.Cid())
+			approvers[sm.Message.From] = true
+			needed--
+			last = sm
+		}
+
+		if needed > 0 {
+			fmt.Fprintf(w, "%d more approval(s) needed to execute transaction %d\n", needed, *b.TxnID)
+		}
+		if last == nil || !cctx.Bool("wait") {
+			return nil
+		}
+		_, err = WaitMessage(ctx, cctx, srv, last.Cid())
+		return err
+	},
+}
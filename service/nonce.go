@@ -0,0 +1,91 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+)
+
+// nonceJournalTTL bounds how long a journal entry may override the mpool
+// nonce. It only has to bridge the time until the node sees our pushes, a
+// message which didn't show up by then was most likely dropped and trusting
+// the journal would leave a nonce gap.
+const nonceJournalTTL = 10 * time.Minute
+
+// NonceJournal remembers the last nonce pushed from each local address so
+// back-to-back sends get consecutive nonces even before the node's mpool
+// reflects the previous message.
+type NonceJournal struct {
+	dir string
+}
+
+type nonceEntry struct {
+	Nonce   uint64    `json:"nonce"`
+	Updated time.Time `json:"updated"`
+}
+
+func OpenNonceJournal() (*NonceJournal, error) {
+	dir, err := ToolDataDir("nonces")
+	if err != nil {
+		return nil, fmt.Errorf("opening nonce journal: %w", err)
+	}
+	return &NonceJournal{dir: dir}, nil
+}
+
+// Next returns the nonce to use for addr given the nonce reported by the
+// mpool, preferring the journal if it is ahead and recent.
+func (j *NonceJournal) Next(addr address.Address, mpoolNonce uint64) (uint64, error) {
+	e, err := j.get(addr)
+	if err != nil {
+		return 0, err
+	}
+	if e == nil || time.Since(e.Updated) > nonceJournalTTL || e.Nonce < mpoolNonce {
+		return mpoolNonce, nil
+	}
+	log.Infof("using journaled nonce %d for %s, mpool reports %d", e.Nonce+1, addr, mpoolNonce)
+	return e.Nonce + 1, nil
+}
+
+// Record stores nonce as pushed from addr
+func (j *NonceJournal) Record(addr address.Address, nonce uint64) error {
+	e, err := j.get(addr)
+	if err != nil {
+		return err
+	}
+	if e != nil && e.Nonce > nonce && time.Since(e.Updated) <= nonceJournalTTL {
+		// a replacement of an older message
+		return nil
+	}
+
+	data, err := json.Marshal(nonceEntry{Nonce: nonce, Updated: time.Now()})
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(j.path(addr), data); err != nil {
+		return fmt.Errorf("recording nonce for %s: %w", addr, err)
+	}
+	return nil
+}
+
+func (j *NonceJournal) get(addr address.Address) (*nonceEntry, error) {
+	data, err := os.ReadFile(j.path(addr))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading nonce journal for %s: %w", addr, err)
+	}
+
+	var e nonceEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("decoding nonce journal for %s: %w", addr, err)
+	}
+	return &e, nil
+}
+
+func (j *NonceJournal) path(addr address.Address) string {
+	return filepath.Join(j.dir, addr.String())
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+)
+
+const (
+	MessageFileVersion = 1
+
+	MessageFileUnsigned = "unsigned-message"
+	MessageFileSigned   = "signed-message"
+)
+
+// MessageFile is the versioned on-disk format used to move messages between
+// the online and the offline machine. Summary only exists for human review,
+// the message itself is always the source of truth.
+type MessageFile struct {
+	Version   int               `json:"version"`
+	Kind      string            `json:"kind"`
+	Summary   MessageSummary    `json:"summary"`
+	Message   types.Message     `json:"message"`
+	Signature *crypto.Signature `json:"signature,omitempty"`
+}
+
+type MessageSummary struct {
+	Cid        string `json:"cid"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Value      string `json:"value"`
+	Method     uint64 `json:"method"`
+	Params     string `json:"params,omitempty"`
+	Nonce      uint64 `json:"nonce"`
+	GasLimit   int64  `json:"gas_limit"`
+	GasFeeCap  string `json:"gas_fee_cap"`
+	GasPremium string `json:"gas_premium"`
+	MaxFee     string `json:"max_fee"`
+}
+
+func NewMessageSummary(msg *types.Message) MessageSummary {
+	return MessageSummary{
+		Cid:        msg.Cid().String(),
+		From:       msg.From.String(),
+		To:         msg.To.String(),
+		Value:      types.FIL(msg.Value).String(),
+		Method:     uint64(msg.Method),
+		Params:     hex.EncodeToString(msg.Params),
+		Nonce:      msg.Nonce,
+		GasLimit:   msg.GasLimit,
+		GasFeeCap:  msg.GasFeeCap.String(),
+		GasPremium: msg.GasPremium.String(),
+		MaxFee:     types.FIL(big.Mul(msg.GasFeeCap, big.NewInt(msg.GasLimit))).String(),
+	}
+}
+
+func WriteMessageFile(path string, kind string, msg *types.Message, sig *crypto.Signature) error {
+	data, err := json.MarshalIndent(MessageFile{
+		Version:   MessageFileVersion,
+		Kind:      kind,
+		Summary:   NewMessageSummary(msg),
+		Message:   *msg,
+		Signature: sig,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func ReadMessageFile(path string, kind string) (*MessageFile, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var mf MessageFile
+	if err := json.Unmarshal(data, &mf); err != nil {
+		return nil, xerrors.Errorf("decoding message file: %w", err)
+	}
+	if mf.Version != MessageFileVersion {
+		return nil, xerrors.Errorf("unsupported message file version %d", mf.Version)
+	}
+	if mf.Kind != kind {
+		return nil, xerrors.Errorf("expected a %s file, got %s", kind, mf.Kind)
+	}
+	if kind == MessageFileSigned && mf.Signature == nil {
+		return nil, xerrors.Errorf("signed message file has no signature")
+	}
+
+	return &mf, nil
+}
+
+func printMessageSummary(w io.Writer, msg *types.Message) {
+	s := NewMessageSummary(msg)
+	fmt.Fprintf(w, "Message:     %s\n", s.Cid)
+	fmt.Fprintf(w, "From:        %s\n", s.From)
+	fmt.Fprintf(w, "To:          %s\n", s.To)
+	fmt.Fprintf(w, "Value:       %s\n", s.Value)
+	fmt.Fprintf(w, "Method:      %d\n", s.Method)
+	if s.Params != "" {
+		fmt.Fprintf(w, "Params:      %s\n", s.Params)
+	}
+	fmt.Fprintf(w, "Nonce:       %d\n", s.Nonce)
+	fmt.Fprintf(w, "Gas Limit:   %d\n", s.GasLimit)
+	fmt.Fprintf(w, "Gas FeeCap:  %s\n", s.GasFeeCap)
+	fmt.Fprintf(w, "Gas Premium: %s\n", s.GasPremium)
+	fmt.Fprintf(w, "Max Fee:     %s\n", s.MaxFee)
+}
+
+var SignMessageCmd = &cli.Command{
+	Name:      "sign-message",
+	Usage:     "Sign an unsigned message file with the local wallet, without network access",
+	ArgsUsage: "<unsigned message file>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "out",
+			Usage: "write the signed message file to this path ('-' for stdout)",
+			Value: "-",
+		},
+		&cli.BoolFlag{
+			Name:  "yes",
+			Usage: "do not ask for confirmation before signing",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+
+		mf, err := ReadMessageFile(cctx.Args().First(), MessageFileUnsigned)
+		if err != nil {
+			return err
+		}
+
+		srv, err := NewOfflineLotusService()
+		if err != nil {
+			return err
+		}
+
+		ctx := lcli.ReqContext(cctx)
+		msg := &mf.Message
+
+		printMessageSummary(os.Stderr, msg)
+		if !cctx.Bool("yes") && !askUser(os.Stderr, "Sign this message? [yes/No]: ", false) {
+			return ErrAbortedByUser
+		}
+
+		sm, err := srv.WalletSignMessage(ctx, msg.From, msg)
+		if err != nil {
+			return err
+		}
+
+		return WriteMessageFile(cctx.String("out"), MessageFileSigned, &sm.Message, &sm.Signature)
+	},
+}
+
+var PushCmd = &cli.Command{
+	Name:      "push",
+	Usage:     "Push a signed message file to the network",
+	ArgsUsage: "<signed message file>",
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+
+		mf, err := ReadMessageFile(cctx.Args().First(), MessageFileSigned)
+		if err != nil {
+			return err
+		}
+
+		srv, err := NewLotusService(cctx)
+		if err != nil {
+			return err
+		}
+		defer srv.Close() //nolint:errcheck
+
+		ctx := lcli.ReqContext(cctx)
+
+		sm := &types.SignedMessage{
+			Message:   mf.Message,
+			Signature: *mf.Signature,
+		}
+		if err := srv.PushSigned(ctx, sm); err != nil {
+			return xerrors.Errorf("pushing message: %w", err)
+		}
+
+		fmt.Fprintf(cctx.App.Writer, "%s\n", sm.Cid())
+		return nil
+	},
+}
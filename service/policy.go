@@ -0,0 +1,311 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/messagesigner"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+const (
+	policyDir       = "policy"
+	policyFileName  = "policy.toml"
+	spentLedgerName = "spent.json"
+
+	spendWindow = 24 * time.Hour
+)
+
+// Policy restricts what the local wallet signs. It is loaded from
+// policy/policy.toml under tool_path, without that file every signature is
+// allowed. Addresses without their own [[address]] rule use the [default]
+// rule, if there is none either they may not sign at all.
+type Policy struct {
+	Default *PolicyRule   `toml:"default"`
+	Address []*PolicyRule `toml:"address"`
+
+	rules  map[address.Address]*PolicyRule
+	ledger string
+}
+
+// PolicyRule lists the restrictions for one address, empty fields don't
+// restrict anything. Value limits only consider the value transferred by
+// chain messages, not gas. A rule with value, recipient or method limits only
+// allows signing chain messages.
+type PolicyRule struct {
+	Addr              string   `toml:"address"`
+	MaxValue          string   `toml:"max_value"`
+	DailyLimit        string   `toml:"daily_limit"`
+	AllowedRecipients []string `toml:"allowed_recipients"`
+	AllowedMethods    []uint64 `toml:"allowed_methods"`
+	AllowedTypes      []string `toml:"allowed_types"`
+
+	maxValue   *abi.TokenAmount
+	dailyLimit *abi.TokenAmount
+	recipients map[address.Address]bool
+	methods    map[abi.MethodNum]bool
+	types      map[api.MsgType]bool
+}
+
+// PolicyViolation is returned for signatures the policy doesn't allow
+type PolicyViolation struct {
+	Signer address.Address
+	Rule   string
+	Reason string
+}
+
+func (e *PolicyViolation) Error() string {
+	return fmt.Sprintf("signing policy rejected signature by %s: rule '%s': %s", e.Signer, e.Rule, e.Reason)
+}
+
+// LoadPolicy reads the signing policy, it returns nil if there is none
+func LoadPolicy() (*Policy, error) {
+	dir, err := ToolDataDir(policyDir)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, policyFileName)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	var p Policy
+	if _, err := toml.DecodeFile(path, &p); err != nil {
+		return nil, fmt.Errorf("failed load policy file, path: %s, error: %w", path, err)
+	}
+
+	p.rules = map[address.Address]*PolicyRule{}
+	p.ledger = filepath.Join(dir, spentLedgerName)
+
+	if p.Default != nil {
+		if err := p.Default.parse(); err != nil {
+			return nil, xerrors.Errorf("policy default rule: %w", err)
+		}
+	}
+	for _, r := range p.Address {
+		addr, err := address.NewFromString(r.Addr)
+		if err != nil {
+			return nil, xerrors.Errorf("policy rule address %q: %w", r.Addr, err)
+		}
+		if err := r.parse(); err != nil {
+			return nil, xerrors.Errorf("policy rule for %s: %w", addr, err)
+		}
+		p.rules[addr] = r
+	}
+
+	return &p, nil
+}
+
+func (r *PolicyRule) parse() error {
+	if r.MaxValue != "" {
+		v, err := types.ParseFIL(r.MaxValue)
+		if err != nil {
+			return xerrors.Errorf("max_value: %w", err)
+		}
+		r.maxValue = (*abi.TokenAmount)(&v)
+	}
+	if r.DailyLimit != "" {
+		v, err := types.ParseFIL(r.DailyLimit)
+		if err != nil {
+			return xerrors.Errorf("daily_limit: %w", err)
+		}
+		r.dailyLimit = (*abi.TokenAmount)(&v)
+	}
+
+	if len(r.AllowedRecipients) > 0 {
+		r.recipients = map[address.Address]bool{}
+		for _, s := range r.AllowedRecipients {
+			a, err := address.NewFromString(s)
+			if err != nil {
+				return xerrors.Errorf("allowed_recipients %q: %w", s, err)
+			}
+			r.recipients[a] = true
+		}
+	}
+	if len(r.AllowedMethods) > 0 {
+		r.methods = map[abi.MethodNum]bool{}
+		for _, m := range r.AllowedMethods {
+			r.methods[abi.MethodNum(m)] = true
+		}
+	}
+	if len(r.AllowedTypes) > 0 {
+		r.types = map[api.MsgType]bool{}
+		for _, t := range r.AllowedTypes {
+			switch api.MsgType(t) {
+			case api.MTUnknown, api.MTChainMsg, api.MTBlock, api.MTDealProposal:
+			default:
+				return xerrors.Errorf("allowed_types: unknown message type %q", t)
+			}
+			r.types[api.MsgType(t)] = true
+		}
+	}
+	return nil
+}
+
+// signedChainMessage decodes the chain message carried in meta and checks
+// toSign are its signing bytes, so the message can be trusted to describe
+// what is signed
+func signedChainMessage(signer address.Address, toSign []byte, meta api.MsgMeta) (*types.Message, error) {
+	msg, err := types.DecodeMessage(meta.Extra)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding chain message to sign: %w", err)
+	}
+	sb, err := messagesigner.SigningBytes(msg, signer.Protocol())
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(sb, toSign) {
+		return nil, xerrors.Errorf("signed bytes don't match the message %s", msg.Cid())
+	}
+	return msg, nil
+}
+
+// limitsMessages reports whether the rule restricts chain message contents
+func (r *PolicyRule) limitsMessages() bool {
+	return r.maxValue != nil || r.dailyLimit != nil || r.recipients != nil || r.methods != nil
+}
+
+// Check verifies that signer may sign toSign. For chain messages the message
+// carried in meta is checked against the signed bytes and returned so the
+// spend can be recorded once signed.
+func (p *Policy) Check(signer address.Address, toSign []byte, meta api.MsgMeta) (*types.Message, error) {
+	rule, ok := p.rules[signer]
+	if !ok {
+		rule = p.Default
+	}
+	if rule == nil {
+		return nil, &PolicyViolation{signer, "address", "no policy rule for this address and no default rule"}
+	}
+
+	if rule.types != nil && !rule.types[meta.Type] {
+		return nil, &PolicyViolation{signer, "allowed_types", fmt.Sprintf("signing %q data is not allowed", meta.Type)}
+	}
+
+	if meta.Type != api.MTChainMsg {
+		// the limits can only be checked on chain messages, signing message
+		// bytes as another type must not get around them
+		if rule.limitsMessages() {
+			return nil, &PolicyViolation{signer, "allowed_types", fmt.Sprintf("only chain messages may be signed when value, recipient or method limits are set, got %q", meta.Type)}
+		}
+		return nil, nil
+	}
+
+	msg, err := signedChainMessage(signer, toSign, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	if rule.maxValue != nil && msg.Value.GreaterThan(*rule.maxValue) {
+		return nil, &PolicyViolation{signer, "max_value",
+			fmt.Sprintf("value %s exceeds %s", types.FIL(msg.Value), types.FIL(*rule.maxValue))}
+	}
+	if rule.recipients != nil && !rule.recipients[msg.To] {
+		return nil, &PolicyViolation{signer, "allowed_recipients", fmt.Sprintf("recipient %s is not allowed", msg.To)}
+	}
+	if rule.methods != nil && !rule.methods[msg.Method] {
+		return nil, &PolicyViolation{signer, "allowed_methods", fmt.Sprintf("method %d is not allowed", msg.Method)}
+	}
+
+	if rule.dailyLimit != nil {
+		spent, err := p.spent(signer)
+		if err != nil {
+			return nil, err
+		}
+		if total := big.Add(spent, msg.Value); total.GreaterThan(*rule.dailyLimit) {
+			return nil, &PolicyViolation{signer, "daily_limit",
+				fmt.Sprintf("%s already signed in the last 24h, %s more exceeds %s",
+					types.FIL(spent), types.FIL(msg.Value), types.FIL(*rule.dailyLimit))}
+		}
+	}
+
+	return msg, nil
+}
+
+type spendEntry struct {
+	Time  time.Time       `json:"time"`
+	Cid   string          `json:"cid"`
+	Value abi.TokenAmount `json:"value"`
+}
+
+// lock keeps other processes from updating the spend ledger, it must be held
+// from Check until the signature is recorded
+func (p *Policy) lock() (io.Closer, error) {
+	return lockDir(filepath.Dir(p.ledger))
+}
+
+// Record adds a signed message to the spend ledger used for daily limits
+func (p *Policy) Record(signer address.Address, msg *types.Message) error {
+	ledger, err := p.readLedger()
+	if err != nil {
+		return err
+	}
+
+	ledger[signer.String()] = append(ledger[signer.String()], spendEntry{
+		Time:  time.Now(),
+		Cid:   msg.Cid().String(),
+		Value: msg.Value,
+	})
+
+	for a, entries := range ledger {
+		var keep []spendEntry
+		for _, e := range entries {
+			if time.Since(e.Time) < spendWindow {
+				keep = append(keep, e)
+			}
+		}
+		if len(keep) == 0 {
+			delete(ledger, a)
+		} else {
+			ledger[a] = keep
+		}
+	}
+
+	data, err := json.Marshal(ledger)
+	if err != nil {
+		return err
+	}
+	return replaceFile(p.ledger, data)
+}
+
+func (p *Policy) spent(signer address.Address) (abi.TokenAmount, error) {
+	ledger, err := p.readLedger()
+	if err != nil {
+		return big.Zero(), err
+	}
+
+	spent := big.Zero()
+	for _, e := range ledger[signer.String()] {
+		if time.Since(e.Time) < spendWindow {
+			spent = big.Add(spent, e.Value)
+		}
+	}
+	return spent, nil
+}
+
+func (p *Policy) readLedger() (map[string][]spendEntry, error) {
+	ledger := map[string][]spendEntry{}
+
+	data, err := os.ReadFile(p.ledger)
+	if os.IsNotExist(err) {
+		return ledger, nil
+	} else if err != nil {
+		return nil, xerrors.Errorf("reading spend ledger: %w", err)
+	}
+	if err := json.Unmarshal(data, &ledger); err != nil {
+		return nil, xerrors.Errorf("decoding spend ledger: %w", err)
+	}
+	return ledger, nil
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/messagesigner"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func testPolicy(t *testing.T, rule *PolicyRule) *Policy {
+	if err := rule.parse(); err != nil {
+		t.Fatal(err)
+	}
+	return &Policy{
+		Default: rule,
+		rules:   map[address.Address]*PolicyRule{},
+		ledger:  filepath.Join(t.TempDir(), spentLedgerName),
+	}
+}
+
+func testSigner(t *testing.T) address.Address {
+	pub := make([]byte, 65)
+	pub[0] = 4
+	a, err := address.NewSecp256k1Address(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func testChainMsg(t *testing.T, from address.Address, value string) (*types.Message, []byte, api.MsgMeta) {
+	to, err := address.NewIDAddress(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := types.ParseFIL(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &types.Message{
+		From:       from,
+		To:         to,
+		Value:      big.Int(v),
+		GasFeeCap:  big.Zero(),
+		GasPremium: big.Zero(),
+	}
+	sb, err := messagesigner.SigningBytes(msg, from.Protocol())
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, err := msg.ToStorageBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg, sb, api.MsgMeta{Type: api.MTChainMsg, Extra: blk.RawData()}
+}
+
+func isViolation(err error, rule string) bool {
+	var v *PolicyViolation
+	return errors.As(err, &v) && v.Rule == rule
+}
+
+func TestPolicyCheckChainMsg(t *testing.T) {
+	signer := testSigner(t)
+	p := testPolicy(t, &PolicyRule{MaxValue: "1"})
+
+	_, sb, meta := testChainMsg(t, signer, "0.5")
+	if _, err := p.Check(signer, sb, meta); err != nil {
+		t.Fatalf("message within max_value rejected: %s", err)
+	}
+
+	_, sb, meta = testChainMsg(t, signer, "2")
+	if _, err := p.Check(signer, sb, meta); !isViolation(err, "max_value") {
+		t.Fatalf("expected max_value violation, got %v", err)
+	}
+
+	// signed bytes of another message than the one in meta
+	_, other, _ := testChainMsg(t, signer, "2")
+	_, _, meta = testChainMsg(t, signer, "0.5")
+	if _, err := p.Check(signer, other, meta); err == nil {
+		t.Fatal("mismatching signed bytes accepted")
+	}
+}
+
+func TestPolicyCheckOtherTypes(t *testing.T) {
+	signer := testSigner(t)
+
+	// chain message bytes signed as unknown data must not bypass the limits
+	_, sb, _ := testChainMsg(t, signer, "2")
+	for _, rule := range []*PolicyRule{
+		{MaxValue: "1"},
+		{DailyLimit: "1"},
+		{AllowedRecipients: []string{"f01000"}},
+		{AllowedMethods: []uint64{0}},
+	} {
+		p := testPolicy(t, rule)
+		for _, typ := range []api.MsgType{api.MTUnknown, api.MTBlock, api.MTDealProposal} {
+			if _, err := p.Check(signer, sb, api.MsgMeta{Type: typ}); !isViolation(err, "allowed_types") {
+				t.Fatalf("%q signature with rule %+v: expected allowed_types violation, got %v", typ, rule, err)
+			}
+		}
+	}
+
+	// without message limits other types follow allowed_types
+	p := testPolicy(t, &PolicyRule{AllowedTypes: []string{string(api.MTUnknown)}})
+	if _, err := p.Check(signer, []byte("data"), api.MsgMeta{Type: api.MTUnknown}); err != nil {
+		t.Fatalf("allowed type rejected: %s", err)
+	}
+	if _, err := p.Check(signer, []byte("data"), api.MsgMeta{Type: api.MTBlock}); !isViolation(err, "allowed_types") {
+		t.Fatalf("expected allowed_types violation, got %v", err)
+	}
+}
+
+func TestPolicyCheckNoRule(t *testing.T) {
+	signer := testSigner(t)
+	p := &Policy{rules: map[address.Address]*PolicyRule{}}
+
+	if _, err := p.Check(signer, []byte("data"), api.MsgMeta{Type: api.MTUnknown}); !isViolation(err, "address") {
+		t.Fatalf("expected address violation, got %v", err)
+	}
+}
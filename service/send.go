@@ -14,7 +14,6 @@ import (
 	"github.com/filecoin-project/go-state-types/abi"
 	builtintypes "github.com/filecoin-project/go-state-types/builtin"
 
-	"github.com/filecoin-project/lotus/chain/actors/builtin"
 	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/chain/types/ethtypes"
 	lcli "github.com/filecoin-project/lotus/cli"
@@ -24,7 +23,10 @@ var SendCmd = &cli.Command{
 	Name:      "send",
 	Usage:     "Send funds between accounts",
 	ArgsUsage: "[targetAddress] [amount]",
-	Flags: []cli.Flag{
+	Subcommands: []*cli.Command{
+		sendBatchCmd,
+	},
+	Flags: append([]cli.Flag{
 		&cli.StringFlag{
 			Name:  "from",
 			Usage: "optionally specify the account to send funds from",
@@ -53,10 +55,10 @@ var SendCmd = &cli.Command{
 			Usage: "specify the nonce to use",
 			Value: 0,
 		},
-		&cli.Uint64Flag{
+		&cli.StringFlag{
 			Name:  "method",
-			Usage: "specify method to invoke",
-			Value: uint64(builtin.MethodSend),
+			Usage: "specify method to invoke by number or name, see 'actor methods <address>'",
+			Value: "0",
 		},
 		&cli.StringFlag{
 			Name:  "params-json",
@@ -70,7 +72,15 @@ var SendCmd = &cli.Command{
 			Name:  "force",
 			Usage: "Deprecated: use global 'force-send'",
 		},
-	},
+		&cli.StringFlag{
+			Name:  "unsigned-out",
+			Usage: "fill nonce and gas, then write the unsigned message to this file instead of sending it",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "simulate the message against the current head without signing or sending it",
+		},
+	}, waitFlags...),
 	Action: func(cctx *cli.Context) error {
 		if cctx.IsSet("force") {
 			fmt.Println("'force' flag is deprecated, use global flag 'force-send'")
@@ -165,7 +175,10 @@ var SendCmd = &cli.Command{
 				}
 			}
 		} else {
-			params.Method = abi.MethodNum(cctx.Uint64("method"))
+			params.Method, err = srv.ResolveMethod(ctx, params.To, cctx.String("method"))
+			if err != nil {
+				return err
+			}
 		}
 
 		if cctx.IsSet("gas-premium") {
@@ -210,6 +223,18 @@ var SendCmd = &cli.Command{
 			return xerrors.Errorf("creating message prototype: %w", err)
 		}
 
+		if out := cctx.String("unsigned-out"); out != "" {
+			msg, err := srv.FillMessage(ctx, proto)
+			if err != nil {
+				return err
+			}
+			return WriteMessageFile(out, MessageFileUnsigned, msg, nil)
+		}
+
+		if cctx.Bool("dry-run") {
+			return DryRun(ctx, cctx.App.Writer, srv, proto)
+		}
+
 		sm, err := InteractiveSend(ctx, cctx, srv, proto)
 		if err != nil {
 			if strings.Contains(err.Error(), "no current EF") {
@@ -219,6 +244,11 @@ var SendCmd = &cli.Command{
 		}
 
 		fmt.Fprintf(cctx.App.Writer, "%s\n", sm.Cid())
+
+		if cctx.Bool("wait") {
+			_, err := WaitMessage(ctx, cctx, srv, sm.Cid())
+			return err
+		}
 		return nil
 	},
 }
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gbrlsnchs/jwt/v3"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/go-jsonrpc/auth"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+
+	"lotus-tools/conf"
+)
+
+const (
+	// same key name and type lotus uses for its api secret, LocalWallet
+	// ignores keys without the wallet- prefix
+	apiSecretName    = "auth-jwt-private" //nolint:gosec
+	apiSecretKeyType = "jwt-hmac-secret"  //nolint:gosec
+
+	defaultWalletListen = "127.0.0.1:1777"
+)
+
+type jwtPayload struct {
+	Allow []auth.Permission
+}
+
+var walletServe = &cli.Command{
+	Name:  "serve",
+	Usage: "Serve the local wallet over JSON-RPC as a lotus remote wallet backend",
+	Description: `Exposes the wallet API at http://<listen>/rpc/v0. Point lotus at it by setting
+   [Wallet] RemoteBackend = "<api key>:http://<listen>" in the lotus config,
+   the api key is printed by 'wallet api-key'.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "listen",
+			Usage: "host address and port the wallet api will listen on (default: [wallet] listen from config, or " + defaultWalletListen + ")",
+		},
+		&cli.DurationFlag{
+			Name:  "http-server-timeout",
+			Value: 30 * time.Second,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx, cancel := context.WithCancel(lcli.ReqContext(cctx))
+		defer cancel()
+
+		kstore, err := OpenKeystore()
+		if err != nil {
+			return err
+		}
+		if err := kstore.Unlock(); err != nil {
+			return xerrors.Errorf("unlocking keystore: %w", err)
+		}
+
+		authKey, err := apiSecret(kstore)
+		if err != nil {
+			return xerrors.Errorf("setting up api secret: %w", err)
+		}
+
+		localWallet, err := newWallet(kstore)
+		if err != nil {
+			return err
+		}
+
+		rpcServer := jsonrpc.NewServer(jsonrpc.WithServerErrors(api.RPCErrors))
+		rpcServer.Register("Filecoin", api.PermissionedWalletAPI(localWallet))
+
+		mux := http.NewServeMux()
+		mux.Handle("/rpc/v0", rpcServer)
+
+		srv := &http.Server{
+			Handler: &auth.Handler{
+				Verify: func(ctx context.Context, token string) ([]auth.Permission, error) {
+					var payload jwtPayload
+					if _, err := jwt.Verify([]byte(token), jwt.NewHS256(authKey), &payload); err != nil {
+						return nil, xerrors.Errorf("JWT Verification failed: %w", err)
+					}
+					return payload.Allow, nil
+				},
+				Next: mux.ServeHTTP,
+			},
+			ReadHeaderTimeout: cctx.Duration("http-server-timeout"),
+		}
+
+		go func() {
+			<-ctx.Done()
+			log.Warn("Shutting down...")
+			if err := srv.Shutdown(context.TODO()); err != nil {
+				log.Errorf("shutting down RPC server failed: %s", err)
+			}
+		}()
+
+		listen := cctx.String("listen")
+		if listen == "" {
+			cfg, err := conf.GetConfig()
+			if err != nil {
+				return err
+			}
+			listen = cfg.Wallet.Listen
+		}
+		if listen == "" {
+			listen = defaultWalletListen
+		}
+
+		nl, err := net.Listen("tcp", listen)
+		if err != nil {
+			return err
+		}
+
+		log.Infof("wallet api listening on http://%s/rpc/v0, use 'wallet api-key' to get an API key", nl.Addr())
+		if err := srv.Serve(nl); err != http.ErrServerClosed {
+			return err
+		}
+		return nil
+	},
+}
+
+var walletAPIKey = &cli.Command{
+	Name:  "api-key",
+	Usage: "Print an API key for 'wallet serve'",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "perm",
+			Usage: "permission of the key: read, write, sign or admin; admin allows exporting private keys",
+			Value: "sign",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		// permissions are cumulative, each one includes the ones before it
+		perm := cctx.String("perm")
+		idx := -1
+		for i, p := range api.AllPermissions {
+			if auth.Permission(perm) == p {
+				idx = i
+			}
+		}
+		if idx == -1 {
+			return xerrors.Errorf("--perm must be one of %s", api.AllPermissions)
+		}
+
+		kstore, err := OpenKeystore()
+		if err != nil {
+			return err
+		}
+
+		authKey, err := apiSecret(kstore)
+		if err != nil {
+			return xerrors.Errorf("setting up api secret: %w", err)
+		}
+
+		k, err := jwt.Sign(&jwtPayload{Allow: api.AllPermissions[:idx+1]}, jwt.NewHS256(authKey))
+		if err != nil {
+			return xerrors.Errorf("jwt sign: %w", err)
+		}
+
+		lcli.NewAppFmt(cctx.App).Println(string(k))
+		return nil
+	},
+}
+
+// apiSecret returns the HMAC secret for api tokens, generating it on first use
+func apiSecret(kstore *DiskKeyStore) ([]byte, error) {
+	ki, err := kstore.Get(apiSecretName)
+	if err == nil {
+		return ki.PrivateKey, nil
+	}
+	if !xerrors.Is(err, types.ErrKeyInfoNotFound) {
+		return nil, err
+	}
+
+	sk := make([]byte, 32)
+	if _, err := rand.Read(sk); err != nil {
+		return nil, err
+	}
+	if err := kstore.Put(apiSecretName, types.KeyInfo{
+		Type:       apiSecretKeyType,
+		PrivateKey: sk,
+	}); err != nil {
+		return nil, err
+	}
+	return sk, nil
+}
@@ -11,11 +11,8 @@ import (
 	"github.com/filecoin-project/go-jsonrpc"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
-	"github.com/filecoin-project/go-state-types/builtin"
-	"github.com/filecoin-project/go-state-types/builtin/v11/miner"
 	"github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/build"
-	"github.com/filecoin-project/lotus/chain/consensus/filcns"
 	"github.com/filecoin-project/lotus/chain/messagesigner"
 	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/chain/vm"
@@ -27,9 +24,10 @@ import (
 	cbg "github.com/whyrusleeping/cbor-gen"
 	"golang.org/x/xerrors"
 	"io"
-	"lotus-tools/conf"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 var log = logging.Logger("service")
@@ -37,7 +35,12 @@ var log = logging.Logger("service")
 type LotusService struct {
 	api    api.FullNode
 	closer jsonrpc.ClientCloser
-	wallet api.Wallet
+	wallet *Wallet
+	nonces *NonceJournal
+
+	methodsOnce sync.Once
+	methods     map[cid.Cid]map[abi.MethodNum]vm.MethodMeta
+	methodsErr  error
 }
 
 func NewLotusService(ctx *cli.Context) (*LotusService, error) {
@@ -48,12 +51,32 @@ func NewLotusService(ctx *cli.Context) (*LotusService, error) {
 
 	localWallet, err := GetWallet()
 	if err != nil {
+		closer()
+		return nil, err
+	}
+
+	nonces, err := OpenNonceJournal()
+	if err != nil {
+		closer()
 		return nil, err
 	}
 	return &LotusService{
 		api:    api,
 		closer: closer,
 		wallet: localWallet,
+		nonces: nonces,
+	}, nil
+}
+
+// NewOfflineLotusService returns a service which can only sign with the local
+// wallet, it never connects to a full node
+func NewOfflineLotusService() (*LotusService, error) {
+	localWallet, err := GetWallet()
+	if err != nil {
+		return nil, err
+	}
+	return &LotusService{
+		wallet: localWallet,
 	}, nil
 }
 
@@ -62,6 +85,9 @@ func (s *LotusService) FullNodeAPI() api.FullNode {
 }
 
 func (s *LotusService) Close() error {
+	if s.api == nil {
+		return nil
+	}
 	if s.closer == nil {
 		return xerrors.Errorf("Services already closed")
 	}
@@ -71,64 +97,134 @@ func (s *LotusService) Close() error {
 }
 
 func (s *LotusService) DecodeTypedParamsFromJSON(ctx context.Context, to address.Address, method abi.MethodNum, paramstr string) ([]byte, error) {
-	act, err := s.api.StateGetActor(ctx, to, types.EmptyTSK)
+	methodMeta, err := s.methodMeta(ctx, to, method)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Info(act)
+	p := reflect.New(methodMeta.Params.Elem()).Interface().(cbg.CBORMarshaler)
 
-	methods := filcns.NewActorRegistry().Methods
+	if err := json.Unmarshal([]byte(paramstr), p); err != nil {
+		return nil, fmt.Errorf("unmarshaling input into params type: %w", err)
+	}
 
-	for _, cidStr := range conf.GetConfig().Actor.Cids {
-		localCid := cid.MustParse(cidStr)
-		var exports = map[abi.MethodNum]builtin.MethodMeta{
-			16: {"WithdrawBalance", *new(func(*miner.WithdrawBalanceParams) *abi.TokenAmount)}, // WithdrawBalance
-			23: {"ChangeOwnerAddress", *new(func(*address.Address) *abi.EmptyValue)},           // ChangeOwnerAddress
-		}
+	buf := new(bytes.Buffer)
+	if err := p.MarshalCBOR(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
 
-		mm := map[abi.MethodNum]vm.MethodMeta{}
-		for number, export := range exports {
-			ev := reflect.ValueOf(export.Method)
-			et := ev.Type()
+// EncodeParams encodes params for method on the actor at to with the params
+// type the actor uses, params must marshal to the same JSON as that type
+func (s *LotusService) EncodeParams(ctx context.Context, to address.Address, method abi.MethodNum, params interface{}) ([]byte, error) {
+	js, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	return s.DecodeTypedParamsFromJSON(ctx, to, method, string(js))
+}
 
-			mm[number] = vm.MethodMeta{
-				Name:   export.Name,
-				Ret:    et.Out(0),
-				Params: et.In(0),
-			}
-		}
-		methods[localCid] = mm
+// DecodeParamsToJSON decodes the params of method invoked on to
+func (s *LotusService) DecodeParamsToJSON(ctx context.Context, to address.Address, method abi.MethodNum, params []byte) (string, error) {
+	methodMeta, err := s.methodMeta(ctx, to, method)
+	if err != nil {
+		return "", err
 	}
 
-	//for k, v := range methods {
-	//	fmt.Println(k)
-	//	for k1, v1 := range v {
-	//		fmt.Println(k1, " ", v1)
-	//	}
-	//}
+	p := reflect.New(methodMeta.Params.Elem()).Interface().(cbg.CBORUnmarshaler)
+	if err := p.UnmarshalCBOR(bytes.NewReader(params)); err != nil {
+		return "", fmt.Errorf("unmarshaling params: %w", err)
+	}
 
-	methodMeta, found := methods[act.Code][method] // TODO: use remote map
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// DecodeReturnToJSON decodes the return value of method invoked on to
+func (s *LotusService) DecodeReturnToJSON(ctx context.Context, to address.Address, method abi.MethodNum, ret []byte) (string, error) {
+	methodMeta, err := s.methodMeta(ctx, to, method)
+	if err != nil {
+		return "", err
+	}
+
+	r := reflect.New(methodMeta.Ret.Elem()).Interface().(cbg.CBORUnmarshaler)
+	if err := r.UnmarshalCBOR(bytes.NewReader(ret)); err != nil {
+		return "", fmt.Errorf("unmarshaling return value: %w", err)
+	}
+
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (s *LotusService) methodMeta(ctx context.Context, to address.Address, method abi.MethodNum) (vm.MethodMeta, error) {
+	code, methods, err := s.ActorMethodTable(ctx, to)
+	if err != nil {
+		return vm.MethodMeta{}, err
+	}
+
+	methodMeta, found := methods[method] // TODO: use remote map
 	if !found {
-		return nil, fmt.Errorf("method %d not found on actor %s", method, act.Code)
+		return vm.MethodMeta{}, fmt.Errorf("method %d not found on actor %s", method, code)
 	}
+	return methodMeta, nil
+}
 
-	p := reflect.New(methodMeta.Params.Elem()).Interface().(cbg.CBORMarshaler)
+// ActorMethodTable returns the code CID of the actor at addr and its methods
+func (s *LotusService) ActorMethodTable(ctx context.Context, addr address.Address) (cid.Cid, map[abi.MethodNum]vm.MethodMeta, error) {
+	act, err := s.api.StateGetActor(ctx, addr, types.EmptyTSK)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
 
-	if err := json.Unmarshal([]byte(paramstr), p); err != nil {
-		return nil, fmt.Errorf("unmarshaling input into params type: %w", err)
+	methods, err := s.actorMethods(ctx)
+	if err != nil {
+		return cid.Undef, nil, err
 	}
 
-	buf := new(bytes.Buffer)
-	if err := p.MarshalCBOR(buf); err != nil {
-		return nil, err
+	table, found := methods[act.Code]
+	if !found {
+		return cid.Undef, nil, xerrors.Errorf("actor %s has unknown code %s, add its methods to the [actor] config", addr, act.Code)
 	}
-	return buf.Bytes(), nil
+	return act.Code, table, nil
+}
+
+// ResolveMethod parses a method number or looks up a method name on the
+// actor at to
+func (s *LotusService) ResolveMethod(ctx context.Context, to address.Address, method string) (abi.MethodNum, error) {
+	if n, err := strconv.ParseUint(method, 10, 64); err == nil {
+		return abi.MethodNum(n), nil
+	}
+
+	code, methods, err := s.ActorMethodTable(ctx, to)
+	if err != nil {
+		return 0, xerrors.Errorf("resolving method %q: %w", method, err)
+	}
+
+	nums := sortedMethodNums(methods)
+	for _, num := range nums {
+		if strings.EqualFold(methods[num].Name, method) {
+			return num, nil
+		}
+	}
+
+	available := make([]string, 0, len(nums))
+	for _, num := range nums {
+		available = append(available, fmt.Sprintf("%s (%d)", methods[num].Name, num))
+	}
+	return 0, xerrors.Errorf("unknown method %q on actor %s (code %s), available methods: %s",
+		method, to, code, strings.Join(available, ", "))
 }
 
 func (s *LotusService) MessageForSend(ctx context.Context, params lcli.SendParams) (*api.MessagePrototype, error) {
 	if params.From == address.Undef {
-		defaddr, err := s.api.WalletDefaultAddress(ctx)
+		defaddr, err := s.DefaultFrom(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -172,6 +268,16 @@ func (s *LotusService) MessageForSend(ctx context.Context, params lcli.SendParam
 	return prototype, nil
 }
 
+// DefaultFrom returns the address messages are sent from when none is given,
+// this is the default of the local wallet and never the remote node's
+func (s *LotusService) DefaultFrom(ctx context.Context) (address.Address, error) {
+	addr, err := s.wallet.GetDefault()
+	if err != nil {
+		return address.Undef, xerrors.Errorf("no --from given and no local default address, use 'wallet set-default': %w", err)
+	}
+	return addr, nil
+}
+
 var ErrCheckFailed = fmt.Errorf("check has failed")
 
 func (s *LotusService) RunChecksForPrototype(ctx context.Context, prototype *api.MessagePrototype) ([][]api.MessageCheckStatus, error) {
@@ -194,6 +300,15 @@ func (s *LotusService) RunChecksForPrototype(ctx context.Context, prototype *api
 func (s *LotusService) PublishMessage(ctx context.Context,
 	prototype *api.MessagePrototype, force bool) (*types.SignedMessage, [][]api.MessageCheckStatus, error) {
 
+	if !prototype.ValidNonce {
+		nonce, err := s.NextNonce(ctx, prototype.Message.From)
+		if err != nil {
+			return nil, nil, err
+		}
+		prototype.Message.Nonce = nonce
+		prototype.ValidNonce = true
+	}
+
 	gasedMsg, err := s.api.GasEstimateMessageGas(ctx, &prototype.Message, nil, types.EmptyTSK)
 	if err != nil {
 		return nil, nil, xerrors.Errorf("estimating gas: %w", err)
@@ -214,29 +329,57 @@ func (s *LotusService) PublishMessage(ctx context.Context,
 		}
 	}
 
-	if prototype.ValidNonce {
+	sm, err := s.WalletSignMessage(ctx, prototype.Message.From, &prototype.Message)
+	if err != nil {
+		log.Errorf("WalletSignMessage failed, error: %+v", err)
+		return nil, nil, err
+	}
 
-		sm, err := s.WalletSignMessage(ctx, prototype.Message.From, &prototype.Message)
-		if err != nil {
-			log.Errorf("WalletSignMessage failed, error: %+v", err)
-			return nil, nil, err
-		}
+	if err := s.PushSigned(ctx, sm); err != nil {
+		log.Errorf("MpoolPush failed, error: %+v", err)
+		return nil, nil, err
+	}
+	return sm, nil, nil
+}
+
+// NextNonce returns the nonce for the next message from addr, taking messages
+// pushed by this tool into account which the node may not have seen yet
+func (s *LotusService) NextNonce(ctx context.Context, addr address.Address) (uint64, error) {
+	nonce, err := s.api.MpoolGetNonce(ctx, addr)
+	if err != nil {
+		return 0, xerrors.Errorf("getting nonce: %w", err)
+	}
+	return s.nonces.Next(addr, nonce)
+}
 
-		_, err = s.api.MpoolPush(ctx, sm)
+// PushSigned pushes a locally signed message and records its nonce
+func (s *LotusService) PushSigned(ctx context.Context, sm *types.SignedMessage) error {
+	if _, err := s.api.MpoolPush(ctx, sm); err != nil {
+		return err
+	}
+	if err := s.nonces.Record(sm.Message.From, sm.Message.Nonce); err != nil {
+		log.Warnf("message %s pushed but not journaled: %s", sm.Cid(), err)
+	}
+	return nil
+}
+
+// FillMessage estimates gas for the prototype and assigns the next nonce if
+// none was given, producing a message ready to be signed
+func (s *LotusService) FillMessage(ctx context.Context, prototype *api.MessagePrototype) (*types.Message, error) {
+	msg := prototype.Message
+	if !prototype.ValidNonce {
+		nonce, err := s.NextNonce(ctx, msg.From)
 		if err != nil {
-			log.Errorf("MpoolPush failed, error: %+v", err)
-			return nil, nil, err
+			return nil, err
 		}
-		return sm, nil, nil
+		msg.Nonce = nonce
 	}
 
-	sm, err := s.api.MpoolPushMessage(ctx, &prototype.Message, nil)
+	gasedMsg, err := s.api.GasEstimateMessageGas(ctx, &msg, nil, types.EmptyTSK)
 	if err != nil {
-		log.Errorf("MpoolPushMessage failed, error: %+v", err)
-		return nil, nil, err
+		return nil, xerrors.Errorf("estimating gas: %w", err)
 	}
-
-	return sm, nil, nil
+	return gasedMsg, nil
 }
 
 func (s *LotusService) WalletSignMessage(ctx context.Context, addr address.Address, msg *types.Message) (*types.SignedMessage, error) {
@@ -277,7 +420,7 @@ func InteractiveSend(ctx context.Context, cctx *cli.Context, srv *LotusService,
 			fmt.Fprintf(printer, "Following checks have failed:\n")
 			printChecks(printer, checks, proto.Message.Cid())
 		} else {
-			proto, err = resolveChecks(ctx, *srv, cctx.App.Writer, proto, checks)
+			proto, err = resolveChecks(ctx, srv, cctx.App.Writer, proto, checks)
 			if err != nil {
 				return nil, xerrors.Errorf("from UI: %w", err)
 			}
@@ -310,7 +453,7 @@ func printChecks(printer io.Writer, checkGroups [][]api.MessageCheckStatus, prot
 
 var ErrAbortedByUser = errors.New("aborted by user")
 
-func resolveChecks(ctx context.Context, s LotusService, printer io.Writer,
+func resolveChecks(ctx context.Context, s *LotusService, printer io.Writer,
 	proto *api.MessagePrototype, checkGroups [][]api.MessageCheckStatus,
 ) (*api.MessagePrototype, error) {
 
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// ExitMessageFailed is the process exit status used when a message was
+// executed on chain with a non-zero exit code
+const ExitMessageFailed = 3
+
+var waitFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:  "wait",
+		Usage: "wait for the message to be included and print the receipt",
+	},
+	&cli.Uint64Flag{
+		Name:  "confidence",
+		Usage: "number of epochs to wait after inclusion",
+		Value: build.MessageConfidence,
+	},
+	&cli.DurationFlag{
+		Name:  "timeout",
+		Usage: "give up waiting after this long",
+		Value: 30 * time.Minute,
+	},
+}
+
+// WaitMessage waits for the message to execute and prints its receipt. A
+// failed execution is returned as an error exiting with ExitMessageFailed.
+func WaitMessage(ctx context.Context, cctx *cli.Context, srv *LotusService, mcid cid.Cid) (*api.MsgLookup, error) {
+	ctx, cancel := context.WithTimeout(ctx, cctx.Duration("timeout"))
+	defer cancel()
+
+	w := cctx.App.Writer
+	fmt.Fprintf(w, "waiting for message %s to be included...\n", mcid)
+
+	lookup, err := srv.api.StateWaitMsg(ctx, mcid, cctx.Uint64("confidence"), api.LookbackNoLimit, true)
+	if err != nil {
+		return nil, xerrors.Errorf("waiting for message: %w", err)
+	}
+
+	fmt.Fprintf(w, "Message:     %s\n", lookup.Message)
+	fmt.Fprintf(w, "Executed at: %d\n", lookup.Height)
+	fmt.Fprintf(w, "Exit Code:   %d (%s)\n", lookup.Receipt.ExitCode, lookup.Receipt.ExitCode)
+	fmt.Fprintf(w, "Gas Used:    %d\n", lookup.Receipt.GasUsed)
+
+	replay, err := srv.api.StateReplay(ctx, types.EmptyTSK, lookup.Message)
+	if err != nil {
+		log.Warnf("replaying message to compute fees: %s", err)
+	} else {
+		burn := big.Add(replay.GasCost.BaseFeeBurn, replay.GasCost.OverEstimationBurn)
+		fmt.Fprintf(w, "Fee Burned:  %s\n", types.FIL(burn))
+		fmt.Fprintf(w, "Total Cost:  %s\n", types.FIL(replay.GasCost.TotalCost))
+	}
+
+	if len(lookup.Receipt.Return) > 0 {
+		msg, err := srv.api.ChainGetMessage(ctx, lookup.Message)
+		if err != nil {
+			return nil, xerrors.Errorf("getting message: %w", err)
+		}
+		ret, err := srv.DecodeReturnToJSON(ctx, msg.To, msg.Method, lookup.Receipt.Return)
+		if err != nil {
+			fmt.Fprintf(w, "Return:      %x (%s)\n", lookup.Receipt.Return, err)
+		} else {
+			fmt.Fprintf(w, "Return:      %s\n", ret)
+		}
+	}
+
+	if lookup.Receipt.ExitCode.IsError() {
+		return lookup, cli.Exit(fmt.Sprintf("message %s failed with exit code %d (%s)",
+			lookup.Message, lookup.Receipt.ExitCode, lookup.Receipt.ExitCode), ExitMessageFailed)
+	}
+	return lookup, nil
+}
@@ -12,6 +12,7 @@ import (
 	"lotus-tools/conf"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/urfave/cli/v2"
 	"golang.org/x/term"
@@ -19,6 +20,7 @@ import (
 
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/crypto"
 	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/lib/tablewriter"
 )
@@ -29,10 +31,16 @@ var WalletCmd = &cli.Command{
 	Subcommands: []*cli.Command{
 		walletNew,
 		walletList,
+		walletGetDefault,
+		walletSetDefault,
 		walletExport,
 		walletImport,
 		walletSign,
 		walletDelete,
+		walletEncrypt,
+		walletSetNetwork,
+		walletServe,
+		walletAPIKey,
 	},
 }
 
@@ -166,6 +174,55 @@ var walletList = &cli.Command{
 	},
 }
 
+var walletGetDefault = &cli.Command{
+	Name:  "default",
+	Usage: "Get default wallet address",
+	Action: func(cctx *cli.Context) error {
+		localWallet, err := GetWallet()
+		if err != nil {
+			return err
+		}
+
+		afmt := lcli.NewAppFmt(cctx.App)
+
+		addr, err := localWallet.GetDefault()
+		if err != nil {
+			return err
+		}
+
+		afmt.Printf("%s\n", addr.String())
+		return nil
+	},
+}
+
+var walletSetDefault = &cli.Command{
+	Name:      "set-default",
+	Usage:     "Set default wallet address",
+	ArgsUsage: "[address]",
+	Action: func(cctx *cli.Context) error {
+		localWallet, err := GetWallet()
+		if err != nil {
+			return err
+		}
+
+		if cctx.NArg() != 1 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+
+		addr, err := address.NewFromString(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		if err := localWallet.SetDefault(addr); err != nil {
+			return xerrors.Errorf("setting default address %s: %w", addr, err)
+		}
+
+		fmt.Println("Default address set to:", addr)
+		return nil
+	},
+}
+
 var walletExport = &cli.Command{
 	Name:      "export",
 	Usage:     "export keys",
@@ -368,18 +425,189 @@ var walletDelete = &cli.Command{
 	},
 }
 
-func GetWallet() (*wallet.LocalWallet, error) {
-	config := conf.GetConfig()
+var walletEncrypt = &cli.Command{
+	Name:  "encrypt",
+	Usage: "Encrypt all plaintext keys in the keystore with a passphrase",
+	Description: `Converts an existing plaintext keystore in place. The passphrase is read from
+   $LOTUS_TOOLS_PASSPHRASE, the file named by $LOTUS_TOOLS_PASSPHRASE_FILE or
+   'passphrase_file', or prompted for. Set 'encrypt_keys = true' in the config
+   so that keys created afterwards are encrypted as well.`,
+	Action: func(cctx *cli.Context) error {
+		kstore, err := OpenKeystore()
+		if err != nil {
+			return err
+		}
+
+		n, err := kstore.Encrypt()
+		if err != nil {
+			return xerrors.Errorf("encrypted %d keys before failing: %w", n, err)
+		}
+
+		fmt.Printf("encrypted %d keys\n", n)
+		if cfg, err := conf.GetConfig(); err == nil && !cfg.Repo.EncryptKeys {
+			fmt.Println("set 'encrypt_keys = true' in the [Repo] config section to encrypt new keys")
+		}
+		return nil
+	},
+}
+
+var walletSetNetwork = &cli.Command{
+	Name:  "set-network",
+	Usage: "Assign the keystore to the network profile selected with --network",
+	Description: `A keystore is only used with the network profile it is assigned to. Empty
+   keystores are assigned on first use, one created before network profiles
+   holds keys for an unknown network and has to be assigned explicitly.`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "yes",
+			Usage: "do not ask for confirmation",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		config, err := conf.GetConfig()
+		if err != nil {
+			return err
+		}
+		if config.Network == "" {
+			return xerrors.Errorf("select the network profile to assign with --network")
+		}
+
+		kstore, err := OpenOrInitKeystore(config.Repo.ToolPath, config.Repo.EncryptKeys,
+			NewPassphraseSource(config.Repo.PassphraseFile))
+		if err != nil {
+			return err
+		}
+
+		marked, err := kstore.network()
+		if err != nil {
+			return err
+		}
+		if marked == config.Network {
+			fmt.Printf("keystore %s already belongs to %s\n", config.Repo.ToolPath, marked)
+			return nil
+		}
+		if marked != "" {
+			return xerrors.Errorf("keystore %s belongs to network %s, it can't be reassigned", config.Repo.ToolPath, marked)
+		}
+
+		keys, err := kstore.List()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("keystore %s holds %d keys\n", config.Repo.ToolPath, len(keys))
+		if !cctx.Bool("yes") && !askUser(cctx.App.Writer, fmt.Sprintf("Use its keys for network %s only? [yes/No]: ", config.Network), false) {
+			return ErrAbortedByUser
+		}
+
+		return kstore.SetNetwork(config.Network)
+	},
+}
+
+// OpenKeystore opens the keystore at tool_path with the configured encryption
+func OpenKeystore() (*DiskKeyStore, error) {
+	config, err := conf.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	ks, err := OpenOrInitKeystore(config.Repo.ToolPath, config.Repo.EncryptKeys,
+		NewPassphraseSource(config.Repo.PassphraseFile))
+	if err != nil {
+		return nil, err
+	}
+	if err := ks.checkNetwork(config.Network); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Wallet is the LocalWallet backed by the DiskKeyStore with every signature
+// checked against the signing policy and recorded in the audit log
+type Wallet struct {
+	*wallet.LocalWallet
+
+	policy *Policy
+	audit  *AuditLog
+	signLk sync.Mutex
+}
+
+func (w *Wallet) WalletSign(ctx context.Context, addr address.Address, msg []byte, meta api.MsgMeta) (*crypto.Signature, error) {
+	// checking, signing and recording must not interleave
+	w.signLk.Lock()
+	defer w.signLk.Unlock()
+
+	// so must a CLI command and a running 'wallet serve' sharing tool_path
+	if w.policy != nil {
+		lk, err := w.policy.lock()
+		if err != nil {
+			return nil, err
+		}
+		defer lk.Close() //nolint:errcheck
+	}
+	auditLk, err := w.audit.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer auditLk.Close() //nolint:errcheck
+
+	var cmsg *types.Message
+	if w.policy != nil {
+		var err error
+		if cmsg, err = w.policy.Check(addr, msg, meta); err != nil {
+			return nil, err
+		}
+	} else if meta.Type == api.MTChainMsg {
+		// the audit log must describe what is actually signed
+		var err error
+		if cmsg, err = signedChainMessage(addr, msg, meta); err != nil {
+			return nil, err
+		}
+	}
+
+	sig, err := w.LocalWallet.WalletSign(ctx, addr, msg, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	// a signature that can't be audited isn't handed out
+	if err := w.audit.Append(addr, msg, meta, cmsg, sig); err != nil {
+		return nil, xerrors.Errorf("recording signature in audit log: %w", err)
+	}
+
+	// nor is one which daily limits wouldn't account for
+	if w.policy != nil && cmsg != nil {
+		if err := w.policy.Record(addr, cmsg); err != nil {
+			return nil, xerrors.Errorf("recording spend of %s: %w", cmsg.Cid(), err)
+		}
+	}
+	return sig, nil
+}
+
+func GetWallet() (*Wallet, error) {
+	kstore, err := OpenKeystore()
+	if err != nil {
+		return nil, err
+	}
+
+	return newWallet(kstore)
+}
+
+func newWallet(kstore *DiskKeyStore) (*Wallet, error) {
+	policy, err := LoadPolicy()
+	if err != nil {
+		return nil, err
+	}
 
-	kstore, err := OpenOrInitKeystore(config.Repo.ToolPath)
+	audit, err := OpenAuditLog()
 	if err != nil {
 		return nil, err
 	}
 
-	localWallet, err := wallet.NewWallet(kstore)
+	lw, err := wallet.NewWallet(kstore)
 	if err != nil {
 		return nil, err
 	}
+	localWallet := &Wallet{LocalWallet: lw, policy: policy, audit: audit}
 
 	addrs, err := localWallet.WalletList(context.TODO())
 	if err != nil {